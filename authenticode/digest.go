@@ -0,0 +1,124 @@
+// Package authenticode computes the Authenticode image digest of a portable executable
+// and reads the digest that the signature was made over.
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	// Register the hashes that Authenticode signatures are made with.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/asn1"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+// https://download.microsoft.com/download/9/c/5/9c5b2167-8017-4bae-9fde-d599bac8184a/Authenticode_PE.docx
+var spcIndirectDataOID = asn1.ObjectIdentifier([]int{1, 3, 6, 1, 4, 1, 311, 2, 1, 4})
+
+var digestAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{asn1.ObjectIdentifier([]int{1, 3, 14, 3, 2, 26}), crypto.SHA1},
+	{asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 1}), crypto.SHA256},
+	{asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 2}), crypto.SHA384},
+	{asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 3}), crypto.SHA512},
+}
+
+// HashFromOID maps a digest algorithm identifier onto the matching hash function.
+func HashFromOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, algorithm := range digestAlgorithms {
+		if algorithm.oid.Equal(oid) {
+			return algorithm.hash, nil
+		}
+	}
+	return 0, errors.NewError(1062)
+}
+
+// ImageDigest parses a portable executable and returns its Authenticode image hash.
+func ImageDigest(contents []byte, hash crypto.Hash) ([]byte, error) {
+	portableExecutable, err := windows.GetPortableExecutable(contents)
+	if err != nil {
+		return nil, err
+	}
+	return Digest(portableExecutable, hash)
+}
+
+// Digest computes the Authenticode image hash the same way Windows does.
+// The CheckSum field, the certificate table data directory entry and the attribute certificate table
+// are excluded, so adding certificates to the table never changes the result.
+func Digest(portableExecutable *structs.PortableExecutable, hash crypto.Hash) ([]byte, error) {
+	if !hash.Available() {
+		return nil, errors.NewError(1062)
+	}
+	if portableExecutable.Contents == nil {
+		return nil, errors.NewError(1036)
+	}
+
+	contents := portableExecutable.Contents
+	checkSumOffset := portableExecutable.CheckSumOffset
+	certificateTableEntry := portableExecutable.CertSizeOffset - 4
+
+	digest := hash.New()
+	digest.Write(contents[:checkSumOffset])
+	digest.Write(contents[checkSumOffset+4 : certificateTableEntry])
+	//The attribute certificate table must be the last thing in the file, so nothing follows it.
+	digest.Write(contents[certificateTableEntry+8 : portableExecutable.AttrCertOffset])
+	return digest.Sum(nil), nil
+}
+
+// SignedDigest returns the image hash stored in the SpcIndirectDataContent of a signature,
+// together with the algorithm it was computed with.
+func SignedDigest(signedData *structs.X509Certificate) (crypto.Hash, []byte, error) {
+	indirectData, err := IndirectData(signedData)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	hash, err := HashFromOID(indirectData.MessageDigest.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return 0, nil, err
+	}
+	return hash, indirectData.MessageDigest.Digest, nil
+}
+
+// IndirectData decodes the SpcIndirectDataContent carried by the ContentInfo of a signature.
+func IndirectData(signedData *structs.X509Certificate) (*structs.SpcIndirectDataContent, error) {
+	var contentInfo structs.ContentInfo
+	if _, err := asn1.Unmarshal(signedData.PKCS7.ContentInfo.FullBytes, &contentInfo); err != nil {
+		return nil, errors.NewError(1061)
+	}
+
+	if !contentInfo.ContentType.Equal(spcIndirectDataOID) {
+		return nil, errors.NewError(1060)
+	}
+
+	var indirectData structs.SpcIndirectDataContent
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &indirectData); err != nil {
+		return nil, errors.NewError(1061)
+	}
+	return &indirectData, nil
+}
+
+// DigestMatches reports whether the image hash of a portable executable is the one its signature was made over.
+func DigestMatches(contents []byte) (bool, error) {
+	portableExecutable, err := windows.GetPortableExecutable(contents)
+	if err != nil {
+		return false, err
+	}
+
+	hash, signed, err := SignedDigest(portableExecutable.X509Certificate)
+	if err != nil {
+		return false, err
+	}
+
+	computed, err := Digest(portableExecutable, hash)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(computed, signed), nil
+}
@@ -0,0 +1,81 @@
+package authenticode_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/RainwayApp/metapod"
+	"github.com/RainwayApp/metapod/authenticode"
+	"github.com/RainwayApp/metapod/structs"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+// Checks that the image digest of contents is the one each of its signatures was made over.
+func digestsMatch(t *testing.T, contents []byte) {
+	t.Helper()
+	portableExecutable, err := windows.GetPortableExecutable(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err := windows.NestedSignatures(portableExecutable.X509Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatures := append([]structs.X509Certificate{*portableExecutable.X509Certificate}, nested...)
+	for index := range signatures {
+		hash, signed, err := authenticode.SignedDigest(&signatures[index])
+		if err != nil {
+			t.Fatal(err)
+		}
+		computed, err := authenticode.Digest(portableExecutable, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(computed, signed) {
+			t.Errorf("signature %d: digest %x, signed %x", index, computed, signed)
+		}
+	}
+	if matches, err := authenticode.DigestMatches(contents); err != nil || !matches {
+		t.Errorf("DigestMatches = %v, %v", matches, err)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	for _, name := range []string{"pe32.exe", "pe32plus.exe"} {
+		t.Run(name, func(t *testing.T) {
+			digestsMatch(t, readFixture(t, name))
+		})
+	}
+}
+
+// Stamping only touches the parts of the file that the image digest leaves out.
+func TestDigestAfterCreate(t *testing.T) {
+	tests := []struct {
+		name      string
+		fixture   string
+		placement metapod.Placement
+		embedding metapod.Embedding
+	}{
+		{"pe32 outer certificate", "pe32.exe", metapod.OuterSignature, metapod.CertificateEmbedding},
+		{"pe32 outer attribute", "pe32.exe", metapod.OuterSignature, metapod.AttributeEmbedding},
+		{"pe32 outer tag", "pe32.exe", metapod.OuterSignature, metapod.TagEmbedding},
+		{"pe32plus outer certificate", "pe32plus.exe", metapod.OuterSignature, metapod.CertificateEmbedding},
+		{"pe32plus outer attribute", "pe32plus.exe", metapod.OuterSignature, metapod.AttributeEmbedding},
+		{"pe32plus outer tag", "pe32plus.exe", metapod.OuterSignature, metapod.TagEmbedding},
+		{"pe32plus nested certificate", "pe32plus.exe", metapod.NestedSignature, metapod.CertificateEmbedding},
+		{"pe32plus nested attribute", "pe32plus.exe", metapod.NestedSignature, metapod.AttributeEmbedding},
+		{"pe32plus nested tag", "pe32plus.exe", metapod.NestedSignature, metapod.TagEmbedding},
+		{"pe32plus both certificate", "pe32plus.exe", metapod.BothSignatures, metapod.CertificateEmbedding},
+		{"pe32plus both attribute", "pe32plus.exe", metapod.BothSignatures, metapod.AttributeEmbedding},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stamped, err := metapod.Create(readFixture(t, test.fixture), []byte("payload"),
+				metapod.WithPlacement(test.placement), metapod.WithEmbedding(test.embedding))
+			if err != nil {
+				t.Fatal(err)
+			}
+			digestsMatch(t, stamped)
+		})
+	}
+}
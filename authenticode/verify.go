@@ -0,0 +1,223 @@
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+)
+
+var (
+	contentTypeOID   = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 9, 3})
+	messageDigestOID = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 9, 4})
+)
+
+// Verification is the outcome of checking the Authenticode signature of a portable executable.
+// The signature is only trustworthy when Failures is empty.
+type Verification struct {
+	// The certificate that produced the signature, nil if it could not be found.
+	Signer *x509.Certificate
+	// Every chain from the signer to one of the trusted roots.
+	Chains [][]*x509.Certificate
+	// The algorithm the image digest was computed with.
+	DigestAlgorithm crypto.Hash
+	// Each check that did not pass, as a MetapodError.
+	Failures []error
+	// The detailed reason the chain could not be built, if it could not.
+	ChainError error
+}
+
+// Valid reports whether every check passed.
+func (verification *Verification) Valid() bool {
+	return len(verification.Failures) == 0
+}
+
+func (verification *Verification) fail(code int) {
+	verification.Failures = append(verification.Failures, errors.NewError(code))
+}
+
+// SignerInfos decodes every SignerInfo of a signature.
+func SignerInfos(signedData *structs.X509Certificate) ([]structs.SignerInfo, error) {
+	var signerInfos []structs.SignerInfo
+	rest := signedData.PKCS7.SignerInfos.Bytes
+	for len(rest) > 0 {
+		var signerInfo structs.SignerInfo
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &signerInfo); err != nil {
+			return nil, errors.NewError(1063)
+		}
+		signerInfos = append(signerInfos, signerInfo)
+	}
+	return signerInfos, nil
+}
+
+// Certificates decodes the certificate set of a signature, skipping entries that are not X.509 certificates.
+func Certificates(signedData *structs.X509Certificate) []*x509.Certificate {
+	var certificates []*x509.Certificate
+	for _, der := range signedData.PKCS7.Certificates {
+		if certificate, err := x509.ParseCertificate(der.FullBytes); err == nil {
+			certificates = append(certificates, certificate)
+		}
+	}
+	return certificates
+}
+
+// Verify checks the Authenticode signature of a portable executable the way Windows does:
+// the signature over the authenticated attributes, the messageDigest of the signed content,
+// the image digest and the certificate chain up to roots (the system roots when nil).
+// The chain is validated against the current time. Countersignatures and RFC 3161 timestamps are ignored,
+// so unlike Windows, Verify fails a file whose signing certificate has expired even when the signature
+// was timestamped while the certificate was still valid.
+// An error is only returned when the signature cannot be decoded at all.
+func Verify(portableExecutable *structs.PortableExecutable, roots *x509.CertPool) (*Verification, error) {
+	signedData := portableExecutable.X509Certificate
+	indirectData, err := IndirectData(signedData)
+	if err != nil {
+		return nil, err
+	}
+
+	signerInfos, err := SignerInfos(signedData)
+	if err != nil {
+		return nil, err
+	}
+	if len(signerInfos) == 0 {
+		return nil, errors.NewError(1064)
+	}
+	//Authenticode allows for exactly one signer, further signatures are nested as attributes.
+	signerInfo := signerInfos[0]
+
+	verification := &Verification{}
+	verification.DigestAlgorithm, err = HashFromOID(indirectData.MessageDigest.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	imageDigest, err := Digest(portableExecutable, verification.DigestAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(imageDigest, indirectData.MessageDigest.Digest) {
+		verification.fail(1070)
+	}
+
+	certificates := Certificates(signedData)
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates {
+		if bytes.Equal(certificate.RawIssuer, signerInfo.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			certificate.SerialNumber.Cmp(signerInfo.IssuerAndSerialNumber.SerialNumber) == 0 {
+			verification.Signer = certificate
+		} else {
+			intermediates.AddCert(certificate)
+		}
+	}
+	if verification.Signer == nil {
+		verification.fail(1065)
+		return verification, nil
+	}
+
+	signerHash, err := HashFromOID(signerInfo.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var contentInfo structs.ContentInfo
+	if _, err := asn1.Unmarshal(signedData.PKCS7.ContentInfo.FullBytes, &contentInfo); err != nil {
+		return nil, errors.NewError(1061)
+	}
+	//The signed content is the body of the SpcIndirectDataContent, without its tag and length.
+	var content asn1.RawValue
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &content); err != nil {
+		return nil, errors.NewError(1061)
+	}
+
+	signed := content.Bytes
+	if len(signerInfo.AuthenticatedAttributes.FullBytes) > 0 {
+		if checkErr := checkAuthenticatedAttributes(signerInfo.AuthenticatedAttributes.Bytes, signerHash, content.Bytes); checkErr != 0 {
+			verification.fail(checkErr)
+		}
+		//The attributes are signed as a SET OF rather than with the implicit [0] tag they are stored with.
+		signed = append([]byte{0x31}, signerInfo.AuthenticatedAttributes.FullBytes[1:]...)
+	}
+
+	signatureAlgorithm := signatureAlgorithmFor(verification.Signer.PublicKeyAlgorithm, signerHash)
+	if signatureAlgorithm == x509.UnknownSignatureAlgorithm ||
+		verification.Signer.CheckSignature(signatureAlgorithm, signed, signerInfo.EncryptedDigest) != nil {
+		verification.fail(1069)
+	}
+
+	verification.Chains, verification.ChainError = verification.Signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if verification.ChainError != nil {
+		verification.fail(1071)
+	}
+
+	return verification, nil
+}
+
+// Checks the contentType and messageDigest attributes, returning the error code of the first mismatch.
+func checkAuthenticatedAttributes(attributes []byte, hash crypto.Hash, content []byte) int {
+	var contentType, messageDigest []byte
+	for len(attributes) > 0 {
+		var attribute structs.Attribute
+		var err error
+		if attributes, err = asn1.Unmarshal(attributes, &attribute); err != nil {
+			return 1066
+		}
+		if attribute.Type.Equal(contentTypeOID) {
+			contentType = attribute.Values.Bytes
+		} else if attribute.Type.Equal(messageDigestOID) {
+			messageDigest = attribute.Values.Bytes
+		}
+	}
+
+	var contentTypeValue asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(contentType, &contentTypeValue); err != nil || !contentTypeValue.Equal(spcIndirectDataOID) {
+		return 1067
+	}
+
+	var messageDigestValue []byte
+	if _, err := asn1.Unmarshal(messageDigest, &messageDigestValue); err != nil {
+		return 1068
+	}
+	digest := hash.New()
+	digest.Write(content)
+	if !bytes.Equal(digest.Sum(nil), messageDigestValue) {
+		return 1068
+	}
+	return 0
+}
+
+// Maps the key of a signer and its digest onto the x509 algorithm used to check the signature.
+func signatureAlgorithmFor(publicKeyAlgorithm x509.PublicKeyAlgorithm, hash crypto.Hash) x509.SignatureAlgorithm {
+	switch publicKeyAlgorithm {
+	case x509.RSA:
+		switch hash {
+		case crypto.SHA1:
+			return x509.SHA1WithRSA
+		case crypto.SHA256:
+			return x509.SHA256WithRSA
+		case crypto.SHA384:
+			return x509.SHA384WithRSA
+		case crypto.SHA512:
+			return x509.SHA512WithRSA
+		}
+	case x509.ECDSA:
+		switch hash {
+		case crypto.SHA1:
+			return x509.ECDSAWithSHA1
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512
+		}
+	}
+	return x509.UnknownSignatureAlgorithm
+}
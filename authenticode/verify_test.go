@@ -0,0 +1,127 @@
+package authenticode_test
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"testing"
+
+	"github.com/RainwayApp/metapod/authenticode"
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+// The signed fixtures of the windows package and the root they chain up to.
+func readFixture(t testing.TB, name string) []byte {
+	contents, err := ioutil.ReadFile("../windows/testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func fixtureRoots(t testing.TB) *x509.CertPool {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(readFixture(t, "root.pem")) {
+		t.Fatal("no certificate in root.pem")
+	}
+	return roots
+}
+
+func failureCodes(verification *authenticode.Verification) []int {
+	var codes []int
+	for _, failure := range verification.Failures {
+		codes = append(codes, failure.(errors.MetapodError).ErrCode())
+	}
+	return codes
+}
+
+func TestVerify(t *testing.T) {
+	roots := fixtureRoots(t)
+	sectionFlipped := func(name string) []byte {
+		contents := readFixture(t, name)
+		headers, err := windows.ReadHeaders(contents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[headers.Sections[0].PointerToRawData] ^= 1
+		return contents
+	}
+
+	tests := []struct {
+		name     string
+		contents []byte
+		roots    *x509.CertPool
+		failures []int
+	}{
+		{"pe32", readFixture(t, "pe32.exe"), roots, nil},
+		{"pe32plus", readFixture(t, "pe32plus.exe"), roots, nil},
+		{"flipped section byte pe32", sectionFlipped("pe32.exe"), roots, []int{1070}},
+		{"flipped section byte pe32plus", sectionFlipped("pe32plus.exe"), roots, []int{1070}},
+		{"no trusted root", readFixture(t, "pe32.exe"), x509.NewCertPool(), []int{1071}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			portableExecutable, err := windows.GetPortableExecutable(test.contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verification, err := authenticode.Verify(portableExecutable, test.roots)
+			if err != nil {
+				t.Fatal(err)
+			}
+			codes := failureCodes(verification)
+			if len(codes) != len(test.failures) {
+				t.Fatalf("failures %v, want %v", codes, test.failures)
+			}
+			for index := range codes {
+				if codes[index] != test.failures[index] {
+					t.Errorf("failures %v, want %v", codes, test.failures)
+				}
+			}
+			if verification.Signer == nil || verification.Signer.Subject.CommonName != "Test Signer" {
+				t.Errorf("Signer = %v", verification.Signer)
+			}
+			if verification.Valid() != (len(test.failures) == 0) {
+				t.Errorf("Valid = %v", verification.Valid())
+			}
+		})
+	}
+}
+
+// A signature whose SpcIndirectDataContent no longer matches the messageDigest attribute signed over it.
+func TestVerifyMessageDigest(t *testing.T) {
+	contents := readFixture(t, "pe32.exe")
+	portableExecutable, err := windows.GetPortableExecutable(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, signed, err := authenticode.SignedDigest(portableExecutable.X509Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//The image digest is stored in the signed content, so changing it breaks both the digest and messageDigest.
+	contents[indexOf(t, contents, signed)] ^= 1
+
+	portableExecutable, err = windows.GetPortableExecutable(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification, err := authenticode.Verify(portableExecutable, fixtureRoots(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := failureCodes(verification)
+	if len(codes) != 2 || codes[0] != 1070 || codes[1] != 1068 {
+		t.Errorf("failures %v, want [1070 1068]", codes)
+	}
+}
+
+func indexOf(t testing.TB, data, value []byte) int {
+	for index := 0; index+len(value) <= len(data); index++ {
+		if string(data[index:index+len(value)]) == string(value) {
+			return index
+		}
+	}
+	t.Fatal("value not found")
+	return -1
+}
@@ -0,0 +1,39 @@
+package metapod
+
+import "testing"
+
+// Reports the time a stamp takes with each type of carrier key, along with the size of the stamped file
+// and how much the carrier added to the stub.
+func BenchmarkStamp(b *testing.B) {
+	stub := readStub(b)
+	template, err := LoadTemplate(stub)
+	if err != nil {
+		b.Fatal(err)
+	}
+	payload := []byte("a login token for the installer")
+	algorithms := []struct {
+		name      string
+		algorithm CarrierKeyAlgorithm
+	}{
+		{"RSA2048", RSA2048},
+		{"ECDSAP256", ECDSAP256},
+		{"Ed25519", Ed25519},
+	}
+	for _, algorithm := range algorithms {
+		b.Run(algorithm.name, func(b *testing.B) {
+			key, err := GenerateCarrierKey(algorithm.algorithm)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var stamped []byte
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if stamped, err = template.Stamp(payload, WithCarrierKey(key)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(stamped)), "output-bytes")
+			b.ReportMetric(float64(len(stamped)-len(stub)), "carrier-bytes")
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"unicode/utf8"
+
+	"github.com/RainwayApp/metapod"
+	"github.com/RainwayApp/metapod/errors"
+)
+
+// Parses the flags of a command that works on exactly one file and returns that file.
+func parse(flags *flag.FlagSet, args []string) (string, error) {
+	flags.SetOutput(ioutil.Discard)
+	if err := flags.Parse(args); err != nil {
+		return "", usageError(err.Error())
+	}
+	if flags.NArg() != 1 {
+		return "", usageError("expected exactly one file")
+	}
+	return flags.Arg(0), nil
+}
+
+func create(args []string) error {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	payloadText := flags.String("payload", "", "the payload")
+	payloadFile := flags.String("payload-file", "", "read the payload from a file, - for stdin")
+	noCheckSum := flags.Bool("no-checksum", false, "keep the CheckSum of the stub")
+	compress := flags.Bool("compress", false, "compress the payload when that saves space")
+	contentType := flags.String("content-type", "", "the media type recorded in the payload envelope")
+	placement := flags.String("placement", "outer", "the signatures carrying the payload: outer, nested or both")
+	embedding := flags.String("embedding", "certificate", "how the payload is stored: certificate, attribute or tag")
+	output := flags.String("out", "-", "the stamped file, - for stdout")
+	name, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	placements := map[string]metapod.Placement{
+		"outer":  metapod.OuterSignature,
+		"nested": metapod.NestedSignature,
+		"both":   metapod.BothSignatures,
+	}
+	if _, ok := placements[*placement]; !ok {
+		return usageError("unknown placement " + *placement)
+	}
+	embeddings := map[string]metapod.Embedding{
+		"certificate": metapod.CertificateEmbedding,
+		"attribute":   metapod.AttributeEmbedding,
+		"tag":         metapod.TagEmbedding,
+	}
+	if _, ok := embeddings[*embedding]; !ok {
+		return usageError("unknown embedding " + *embedding)
+	}
+
+	var payload []byte
+	switch {
+	case *payloadText != "" && *payloadFile != "":
+		return usageError("-payload and -payload-file are mutually exclusive")
+	case name == "-" && *payloadText == "" && (*payloadFile == "" || *payloadFile == "-"):
+		return usageError("the stub and the payload cannot both be read from stdin")
+	case *payloadText != "":
+		payload = []byte(*payloadText)
+	case *payloadFile != "":
+		if payload, err = readFile(*payloadFile); err != nil {
+			return err
+		}
+	default:
+		if payload, err = readFile("-"); err != nil {
+			return err
+		}
+	}
+
+	stub, err := readFile(name)
+	if err != nil {
+		return err
+	}
+
+	var options []metapod.Option
+	if *noCheckSum {
+		options = append(options, metapod.WithoutCheckSum())
+	}
+	if *compress {
+		options = append(options, metapod.WithCompression())
+	}
+	if *contentType != "" {
+		options = append(options, metapod.WithContentType(*contentType))
+	}
+	options = append(options, metapod.WithPlacement(placements[*placement]), metapod.WithEmbedding(embeddings[*embedding]))
+	contents, err := metapod.Create(stub, payload, options...)
+	if err != nil {
+		return err
+	}
+	return writeFile(*output, contents)
+}
+
+func open(args []string) error {
+	flags := flag.NewFlagSet("open", flag.ContinueOnError)
+	format := flags.String("format", "raw", "raw, hex or json")
+	name, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+
+	contents, err := readFile(name)
+	if err != nil {
+		return err
+	}
+	payload, err := metapod.Open(contents)
+	if err != nil {
+		return err
+	}
+	if payload == nil {
+		return errors.NewError(1050)
+	}
+
+	switch *format {
+	case "raw":
+		_, err = os.Stdout.Write(payload)
+	case "hex":
+		_, err = fmt.Println(hex.EncodeToString(payload))
+	case "json":
+		err = printJSON(jsonPayload(payload))
+	default:
+		return usageError("unknown format " + *format)
+	}
+	return err
+}
+
+// A payload that is JSON itself is printed as it is, otherwise it becomes a JSON string
+// (base64 when it is not valid UTF-8).
+func jsonPayload(payload []byte) interface{} {
+	if json.Valid(payload) {
+		return json.RawMessage(payload)
+	}
+	if utf8.Valid(payload) {
+		return string(payload)
+	}
+	return payload
+}
+
+func printJSON(value interface{}) error {
+	encoded, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(encoded))
+	return err
+}
+
+func strip(args []string) error {
+	flags := flag.NewFlagSet("strip", flag.ContinueOnError)
+	output := flags.String("out", "-", "the stripped file, - for stdout")
+	name, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+
+	contents, err := readFile(name)
+	if err != nil {
+		return err
+	}
+	stub, err := metapod.Strip(contents)
+	if err != nil {
+		return err
+	}
+	return writeFile(*output, stub)
+}
+
+func inspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	name, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+
+	contents, err := readFile(name)
+	if err != nil {
+		return err
+	}
+	inspection, err := metapod.Inspect(contents)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(inspection); err != nil {
+		return err
+	}
+	//The report says what went wrong, the exit status should too.
+	if inspection.ErrorCode != 0 {
+		return errors.NewError(inspection.ErrorCode)
+	}
+	return nil
+}
+
+func verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	rootsFile := flags.String("roots", "", "PEM file of trusted roots, the system roots when empty")
+	name, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if name == "-" && *rootsFile == "-" {
+		return usageError("the roots and the file cannot both be read from stdin")
+	}
+
+	var roots *x509.CertPool
+	if *rootsFile != "" {
+		pem, err := readFile(*rootsFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return usageError("no certificates found in " + *rootsFile)
+		}
+	}
+
+	contents, err := readFile(name)
+	if err != nil {
+		return err
+	}
+	verification, err := metapod.Verify(contents, roots)
+	if err != nil {
+		return err
+	}
+
+	report := struct {
+		Valid           bool     `json:"valid"`
+		Signer          string   `json:"signer,omitempty"`
+		Issuer          string   `json:"issuer,omitempty"`
+		DigestAlgorithm string   `json:"digestAlgorithm"`
+		Chains          int      `json:"chains"`
+		Failures        []string `json:"failures,omitempty"`
+		ChainError      string   `json:"chainError,omitempty"`
+	}{
+		Valid:           verification.Valid(),
+		DigestAlgorithm: verification.DigestAlgorithm.String(),
+		Chains:          len(verification.Chains),
+	}
+	if verification.Signer != nil {
+		report.Signer = verification.Signer.Subject.String()
+		report.Issuer = verification.Signer.Issuer.String()
+	}
+	for _, failure := range verification.Failures {
+		report.Failures = append(report.Failures, failure.Error())
+	}
+	if verification.ChainError != nil {
+		report.ChainError = verification.ChainError.Error()
+	}
+	if err := printJSON(report); err != nil {
+		return err
+	}
+
+	if !verification.Valid() {
+		if failure, ok := verification.Failures[0].(errors.MetapodError); ok {
+			return invalidSignature{failure}
+		}
+		return verification.Failures[0]
+	}
+	return nil
+}
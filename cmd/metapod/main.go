@@ -0,0 +1,117 @@
+// Command metapod stamps payloads into signed executables, MSI packages and Mach-O binaries and reads them back.
+//
+// Usage:
+//
+//	metapod create [-payload text | -payload-file file] [-no-checksum] [-compress] [-content-type type]
+//	               [-placement outer|nested|both] [-embedding certificate|attribute|tag] [-out file] stub
+//	metapod open [-format raw|hex|json] file
+//	metapod strip [-out file] file
+//	metapod inspect file
+//	metapod verify [-roots file] file
+//
+// When neither -payload nor -payload-file is given, create reads the payload from stdin.
+// A file name of - stands for stdin or stdout; stdin can only be read once per command.
+//
+// The exit status is one of
+//
+//	0  success
+//	1  a file cannot be read or written
+//	2  usage error
+//	3  the file carries no payload (open)
+//	4  the signature is not valid (verify)
+//	5  any other MetaPod error
+//
+// and the MetaPod error code itself is printed on stderr along with its message.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+const (
+	exitFailure   = 1
+	exitUsage     = 2
+	exitNoPayload = 3
+	exitInvalid   = 4
+	exitError     = 5
+)
+
+var commands = map[string]func(args []string) error{
+	"create":  create,
+	"open":    open,
+	"strip":   strip,
+	"inspect": inspect,
+	"verify":  verify,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command, found := commands[os.Args[1]]
+	if !found {
+		usage()
+	}
+	if err := command(os.Args[2:]); err != nil {
+		if coded, ok := err.(interface{ ErrCode() int }); ok {
+			fmt.Fprintf(os.Stderr, "metapod %s: error %d: %v\n", os.Args[1], coded.ErrCode(), err)
+		} else {
+			fmt.Fprintf(os.Stderr, "metapod %s: %v\n", os.Args[1], err)
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: metapod create|open|strip|inspect|verify [flags] file")
+	os.Exit(exitUsage)
+}
+
+// usageError is returned by commands that were called incorrectly.
+type usageError string
+
+func (u usageError) Error() string {
+	return string(u)
+}
+
+// invalidSignature is returned by verify when a check of the signature failed.
+type invalidSignature struct {
+	errors.MetapodError
+}
+
+// Maps an error onto the exit status documented above.
+func exitCode(err error) int {
+	switch e := err.(type) {
+	case errors.MetapodError:
+		if e.ErrCode() == 1050 {
+			return exitNoPayload
+		}
+		return exitError
+	case invalidSignature:
+		return exitInvalid
+	case usageError:
+		return exitUsage
+	}
+	return exitFailure
+}
+
+// Reads a whole file, - being stdin.
+func readFile(name string) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// Writes a whole file, - being stdout.
+func writeFile(name string, contents []byte) error {
+	if name == "-" {
+		_, err := os.Stdout.Write(contents)
+		return err
+	}
+	return ioutil.WriteFile(name, contents, 0755)
+}
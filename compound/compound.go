@@ -0,0 +1,162 @@
+//Package compound reads and writes OLE compound files, the container format of MSI installers.
+//https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-cfb
+package compound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+//Every compound file starts with this signature.
+var signature = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+
+//Special sector numbers.
+const (
+	maxRegularSector = 0xfffffffa
+	difatSector      = 0xfffffffc
+	fatSector        = 0xfffffffd
+	endOfChain       = 0xfffffffe
+	freeSector       = 0xffffffff
+	noStream         = 0xffffffff
+)
+
+const (
+	headerSize         = 512
+	headerDIFATEntries = 109
+	entrySize          = 128
+	miniSectorSize     = 64
+	miniStreamCutoff   = 4096
+)
+
+//Directory entry types.
+const (
+	TypeUnused  = 0
+	TypeStorage = 1
+	TypeStream  = 2
+	TypeRoot    = 5
+)
+
+//Entry is a directory entry: the root storage, a storage or a stream.
+//Everything but the location and size of the data is kept exactly as it was read.
+type Entry struct {
+	raw [entrySize]byte
+	//The contents of a stream, nil for storages.
+	Data []byte
+}
+
+//Name is the name of the entry.
+func (entry *Entry) Name() string {
+	length := int(binary.LittleEndian.Uint16(entry.raw[64:]))/2 - 1
+	if length < 0 || length > 31 {
+		return ""
+	}
+	name := make([]uint16, length)
+	for index := range name {
+		name[index] = binary.LittleEndian.Uint16(entry.raw[index*2:])
+	}
+	return string(utf16.Decode(name))
+}
+
+//Type is one of TypeStorage, TypeStream or TypeRoot, TypeUnused for a free slot of the directory.
+func (entry *Entry) Type() byte {
+	return entry.raw[66]
+}
+
+//CLSID is the class identifier of a storage.
+func (entry *Entry) CLSID() [16]byte {
+	var clsid [16]byte
+	copy(clsid[:], entry.raw[80:96])
+	return clsid
+}
+
+func (entry *Entry) left() uint32 {
+	return binary.LittleEndian.Uint32(entry.raw[68:])
+}
+
+func (entry *Entry) right() uint32 {
+	return binary.LittleEndian.Uint32(entry.raw[72:])
+}
+
+func (entry *Entry) child() uint32 {
+	return binary.LittleEndian.Uint32(entry.raw[76:])
+}
+
+func (entry *Entry) startSector() uint32 {
+	return binary.LittleEndian.Uint32(entry.raw[116:])
+}
+
+func (entry *Entry) size() uint64 {
+	return binary.LittleEndian.Uint64(entry.raw[120:])
+}
+
+//File is a compound file held in memory.
+type File struct {
+	header [headerSize]byte
+	//Every slot of the directory in order, so that the tree of entries survives untouched.
+	Entries []*Entry
+}
+
+//IsCompoundFile tells whether contents start with the compound file signature.
+func IsCompoundFile(contents []byte) bool {
+	return bytes.HasPrefix(contents, signature)
+}
+
+//sectorShift is 9 for version 3 files and 12 for version 4 files.
+func (file *File) sectorShift() uint {
+	return uint(binary.LittleEndian.Uint16(file.header[30:]))
+}
+
+func (file *File) majorVersion() uint16 {
+	return binary.LittleEndian.Uint16(file.header[26:])
+}
+
+//WithData returns a copy of the file in which the stream at index holds data. The file itself is left untouched,
+//and the copy shares the contents of every other stream with it.
+func (file *File) WithData(index int, data []byte) *File {
+	copied := &File{header: file.header, Entries: append([]*Entry{}, file.Entries...)}
+	entry := *file.Entries[index]
+	entry.Data = data
+	copied.Entries[index] = &entry
+	return copied
+}
+
+//Children lists the entries of the storage at index, in the order of the tree.
+func (file *File) Children(index int) []int {
+	var children []int
+	//Entries are visited at most once, so a malformed tree cannot loop forever.
+	visited := make(map[uint32]bool)
+	var walk func(id uint32)
+	walk = func(id uint32) {
+		if id == noStream || int64(id) >= int64(len(file.Entries)) || visited[id] {
+			return
+		}
+		visited[id] = true
+		entry := file.Entries[id]
+		walk(entry.left())
+		children = append(children, int(id))
+		walk(entry.right())
+	}
+	if index >= 0 && index < len(file.Entries) {
+		walk(file.Entries[index].child())
+	}
+	return children
+}
+
+//Lookup finds an entry by its path from the root storage.
+func (file *File) Lookup(path ...string) (int, bool) {
+	index := 0
+	for _, name := range path {
+		found := false
+		for _, child := range file.Children(index) {
+			if file.Entries[child].Name() == name {
+				index, found = child, true
+				break
+			}
+		}
+		if !found {
+			return -1, false
+		}
+	}
+	return index, len(file.Entries) > 0
+}
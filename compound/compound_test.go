@@ -0,0 +1,182 @@
+package compound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+//The MSI packages of the msi package are small compound files of both versions.
+func readFixture(t testing.TB, name string) []byte {
+	contents, err := ioutil.ReadFile("../msi/testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func errorCode(err error) int {
+	if metapodError, ok := err.(errors.MetapodError); ok {
+		return metapodError.ErrCode()
+	}
+	return 0
+}
+
+//Checks that rewritten holds the same tree as original, with the streams of data in place of theirs.
+func sameEntries(t *testing.T, original, rewritten *File, data map[int][]byte) {
+	t.Helper()
+	if len(rewritten.Entries) != len(original.Entries) {
+		t.Fatalf("%d entries, want %d", len(rewritten.Entries), len(original.Entries))
+	}
+	for index, entry := range rewritten.Entries {
+		want := original.Entries[index]
+		//Only the start sector and the size of an entry may change.
+		if !bytes.Equal(entry.raw[:116], want.raw[:116]) {
+			t.Errorf("entry %d %q: directory entry changed", index, entry.Name())
+		}
+		wantData := want.Data
+		if replaced, found := data[index]; found {
+			wantData = replaced
+		}
+		if entry.Type() == TypeStream && !bytes.Equal(entry.Data, wantData) {
+			t.Errorf("entry %d %q: %d bytes, want %d", index, entry.Name(), len(entry.Data), len(wantData))
+		}
+		if entry.Type() == TypeStream && entry.size() != uint64(len(wantData)) {
+			t.Errorf("entry %d %q: size %d, want %d", index, entry.Name(), entry.size(), len(wantData))
+		}
+	}
+}
+
+func TestReadBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint16
+		shift   uint
+	}{
+		{"signed3.msi", 3, 9},
+		{"signed4.msi", 4, 12},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := Read(readFixture(t, test.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file.majorVersion() != test.version || file.sectorShift() != test.shift {
+				t.Errorf("version %d with sector shift %d", file.majorVersion(), file.sectorShift())
+			}
+			index, found := file.Lookup("sub", "inner")
+			if !found || file.Entries[index].Type() != TypeStream || len(file.Entries[index].Data) != 100 {
+				t.Errorf("Lookup(sub, inner) = %d, %v", index, found)
+			}
+			if _, found := file.Lookup("sub", "missing"); found {
+				t.Error("Lookup found a missing stream")
+			}
+
+			contents, err := file.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(contents)%(1<<test.shift) != 0 {
+				t.Errorf("%d bytes is not a whole number of sectors", len(contents))
+			}
+			rewritten, err := Read(contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sameEntries(t, file, rewritten, nil)
+		})
+	}
+}
+
+func TestWithData(t *testing.T) {
+	sized := func(size int) []byte {
+		return bytes.Repeat([]byte{0x5a}, size)
+	}
+	tests := []struct {
+		name    string
+		fixture string
+		stream  []string
+		data    []byte
+	}{
+		{"grow within the mini stream", "signed3.msi", []string{"sub", "inner"}, sized(3000)},
+		{"grow across the cutoff", "signed3.msi", []string{"\x05DigitalSignature"}, sized(miniStreamCutoff)},
+		{"shrink across the cutoff", "signed3.msi", []string{"䡀㼿䕷䑬㭪䗤䠤"}, sized(miniStreamCutoff - 1)},
+		{"empty", "signed3.msi", []string{"\x05SummaryInformation"}, []byte{}},
+		{"fill an empty stream", "signed3.msi", []string{"䡀䕙䓲䕨䜷"}, sized(10)},
+		{"grow across the cutoff v4", "signed4.msi", []string{"sub", "inner"}, sized(miniStreamCutoff + 1)},
+		{"shrink across the cutoff v4", "signed4.msi", []string{"\x05DigitalSignature"}, sized(64)},
+		//The FAT outgrows the 109 sectors the header can list, so the DIFAT is needed.
+		{"DIFAT", "signed3.msi", []string{"䡀㼿䕷䑬㭪䗤䠤"}, sized(headerDIFATEntries * 128 * 512)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := Read(readFixture(t, test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			index, found := file.Lookup(test.stream...)
+			if !found {
+				t.Fatalf("no stream %q", test.stream)
+			}
+			original := file.Entries[index].Data
+
+			contents, err := file.WithData(index, test.data).Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(file.Entries[index].Data, original) {
+				t.Error("WithData changed the original file")
+			}
+			rewritten, err := Read(contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sameEntries(t, file, rewritten, map[int][]byte{index: test.data})
+			if difatCount := binary.LittleEndian.Uint32(rewritten.header[72:]); test.name == "DIFAT" && difatCount == 0 {
+				t.Error("no DIFAT sector was written")
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	fixture := readFixture(t, "signed3.msi")
+	modified := func(offset int, value ...byte) []byte {
+		contents := append([]byte{}, fixture...)
+		copy(contents[offset:], value)
+		return contents
+	}
+	inner := bytes.Index(fixture, []byte("i\x00n\x00n\x00e\x00r\x00\x00\x00"))
+	//The FAT sector the header lists first, and the entry in it of the first directory sector.
+	fatOffset := int(binary.LittleEndian.Uint32(fixture[76:])+1) << 9
+	directory := binary.LittleEndian.Uint32(fixture[48:])
+	loop := make([]byte, 4)
+	binary.LittleEndian.PutUint32(loop, directory)
+
+	tests := []struct {
+		name     string
+		contents []byte
+		code     int
+	}{
+		{"not a compound file", bytes.Repeat([]byte{0}, headerSize), 1120},
+		{"truncated header", fixture[:headerSize-1], 1120},
+		{"byte order", modified(28, 0xff, 0xff), 1121},
+		{"version and sector size", modified(26, 4), 1121},
+		{"directory past the end", modified(48, 0xf0, 0xff, 0, 0), 1124},
+		//The FAT is stored at the end of the fixture.
+		{"truncated", fixture[:len(fixture)/2], 1125},
+		{"directory chain loop", modified(fatOffset+int(directory)*4, loop...), 1124},
+		{"stream larger than the file", modified(inner+120, 0xff, 0xff, 0xff, 0x7f), 1123},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Read(test.contents); errorCode(err) != test.code {
+				t.Errorf("Read: %v, want error %d", err, test.code)
+			}
+		})
+	}
+}
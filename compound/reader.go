@@ -0,0 +1,168 @@
+package compound
+
+import (
+	"encoding/binary"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+//Read parses a compound file, loading the contents of every stream.
+func Read(contents []byte) (*File, error) {
+	if len(contents) < headerSize || !IsCompoundFile(contents) {
+		return nil, errors.NewError(1120)
+	}
+	file := &File{}
+	copy(file.header[:], contents)
+	shift := file.sectorShift()
+	if binary.LittleEndian.Uint16(file.header[28:]) != 0xfffe ||
+		!(file.majorVersion() == 3 && shift == 9 || file.majorVersion() == 4 && shift == 12) ||
+		binary.LittleEndian.Uint16(file.header[32:]) != 6 {
+		return nil, errors.NewError(1121)
+	}
+
+	reader := &sectorReader{contents: contents, shift: shift}
+	fat, err := reader.fat(file.header[:])
+	if err != nil {
+		return nil, err
+	}
+	reader.table = fat
+
+	directory, err := reader.chain(binary.LittleEndian.Uint32(file.header[48:]))
+	if err != nil {
+		return nil, err
+	}
+	for offset := 0; offset+entrySize <= len(directory); offset += entrySize {
+		entry := &Entry{}
+		copy(entry.raw[:], directory[offset:])
+		file.Entries = append(file.Entries, entry)
+	}
+	if len(file.Entries) == 0 || file.Entries[0].Type() != TypeRoot {
+		return nil, errors.NewError(1122)
+	}
+
+	root := file.Entries[0]
+	miniStream, err := reader.chain(root.startSector())
+	if err != nil {
+		return nil, err
+	}
+	if root.size() > uint64(len(miniStream)) {
+		return nil, errors.NewError(1123)
+	}
+	miniFATBytes, err := reader.chain(binary.LittleEndian.Uint32(file.header[60:]))
+	if err != nil {
+		return nil, err
+	}
+	miniReader := &sectorReader{contents: miniStream[:root.size()], shift: 6, table: uint32s(miniFATBytes), mini: true}
+
+	cutoff := uint64(binary.LittleEndian.Uint32(file.header[56:]))
+	for _, entry := range file.Entries[1:] {
+		if entry.Type() != TypeStream {
+			continue
+		}
+		size := entry.size()
+		if file.majorVersion() == 3 {
+			//Version 3 files may leave garbage in the high half of the size.
+			size &= 0xffffffff
+		}
+		if size == 0 {
+			entry.Data = []byte{}
+			continue
+		}
+		if size > uint64(len(contents)) {
+			return nil, errors.NewError(1123)
+		}
+		source := reader
+		if size < cutoff {
+			source = miniReader
+		}
+		data, err := source.chain(entry.startSector())
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(len(data)) {
+			return nil, errors.NewError(1123)
+		}
+		entry.Data = data[:size]
+	}
+	return file, nil
+}
+
+//Reads sectors of the file, or mini sectors of the mini stream, following an allocation table.
+type sectorReader struct {
+	contents []byte
+	shift    uint
+	table    []uint32
+	mini     bool
+}
+
+//Returns the sector at index, nil when it lies beyond the end of the file.
+func (reader *sectorReader) sector(index uint32) []byte {
+	size := uint64(1) << reader.shift
+	offset := uint64(index) << reader.shift
+	if !reader.mini {
+		//Regular sectors are counted from the end of the header sector.
+		offset += size
+	}
+	if index > maxRegularSector || offset+size > uint64(len(reader.contents)) {
+		return nil
+	}
+	return reader.contents[offset : offset+size]
+}
+
+//Concatenates the sectors of the chain starting at start.
+func (reader *sectorReader) chain(start uint32) ([]byte, error) {
+	var data []byte
+	for index, count := start, 0; index != endOfChain; count++ {
+		//A chain can never hold more sectors than the file, nor be longer than the table describing it,
+		//anything longer is a loop.
+		if count >= len(reader.contents)>>reader.shift || count > len(reader.table) ||
+			int64(index) >= int64(len(reader.table)) {
+			return nil, errors.NewError(1124)
+		}
+		sector := reader.sector(index)
+		if sector == nil {
+			return nil, errors.NewError(1124)
+		}
+		data = append(data, sector...)
+		index = reader.table[index]
+	}
+	return data, nil
+}
+
+//Assembles the FAT from the sectors the DIFAT lists.
+func (reader *sectorReader) fat(header []byte) ([]uint32, error) {
+	fatSectors := binary.LittleEndian.Uint32(header[44:])
+	difat := uint32s(header[76:headerSize])
+	perSector := 1 << reader.shift / 4
+	next := binary.LittleEndian.Uint32(header[68:])
+	for count := 0; next != endOfChain && next != freeSector; count++ {
+		sector := reader.sector(next)
+		if sector == nil || uint32(count) > fatSectors {
+			return nil, errors.NewError(1125)
+		}
+		entries := uint32s(sector)
+		difat = append(difat, entries[:perSector-1]...)
+		next = entries[perSector-1]
+	}
+	if uint64(fatSectors) > uint64(len(difat)) {
+		return nil, errors.NewError(1125)
+	}
+
+	var fat []uint32
+	for _, index := range difat[:fatSectors] {
+		sector := reader.sector(index)
+		if sector == nil {
+			return nil, errors.NewError(1125)
+		}
+		fat = append(fat, uint32s(sector)...)
+	}
+	return fat, nil
+}
+
+func uint32s(data []byte) []uint32 {
+	values := make([]uint32, len(data)/4)
+	for index := range values {
+		values[index] = binary.LittleEndian.Uint32(data[index*4:])
+	}
+	return values
+}
@@ -0,0 +1,171 @@
+package compound
+
+import (
+	"encoding/binary"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+//Lays out the sectors of a file, keeping the FAT that chains them.
+type sectorWriter struct {
+	size int
+	body []byte
+	fat  []uint32
+}
+
+//Stores data in consecutive sectors and returns the first one, or ENDOFCHAIN for no data.
+func (writer *sectorWriter) allocate(data []byte) uint32 {
+	if len(data) == 0 {
+		return endOfChain
+	}
+	start := uint32(len(writer.fat))
+	count := (len(data) + writer.size - 1) / writer.size
+	for index := 1; index < count; index++ {
+		writer.fat = append(writer.fat, start+uint32(index))
+	}
+	writer.fat = append(writer.fat, endOfChain)
+	writer.body = append(writer.body, data...)
+	writer.body = append(writer.body, make([]byte, count*writer.size-len(data))...)
+	return start
+}
+
+//Bytes writes the file out. The header and every directory entry are kept as they were read, only where the
+//streams are stored changes: the streams come one after the other, followed by the mini stream, the directory,
+//the mini FAT, the FAT and the DIFAT.
+func (file *File) Bytes() ([]byte, error) {
+	shift := file.sectorShift()
+	writer := &sectorWriter{size: 1 << shift}
+	perSector := writer.size / 4
+
+	entries := make([][entrySize]byte, len(file.Entries))
+	var miniStream []byte
+	var miniFAT []uint32
+	for index, entry := range file.Entries {
+		entries[index] = entry.raw
+		if index == 0 || entry.Type() != TypeStream {
+			continue
+		}
+		if file.majorVersion() == 3 && uint64(len(entry.Data)) > 0xffffffff {
+			return nil, errors.NewError(1126)
+		}
+		start := uint32(endOfChain)
+		switch size := len(entry.Data); {
+		case size == 0:
+		case size < miniStreamCutoff:
+			start = uint32(len(miniFAT))
+			count := (size + miniSectorSize - 1) / miniSectorSize
+			for sector := 1; sector < count; sector++ {
+				miniFAT = append(miniFAT, start+uint32(sector))
+			}
+			miniFAT = append(miniFAT, endOfChain)
+			miniStream = append(miniStream, entry.Data...)
+			miniStream = append(miniStream, make([]byte, count*miniSectorSize-size)...)
+		default:
+			start = writer.allocate(entry.Data)
+		}
+		binary.LittleEndian.PutUint32(entries[index][116:], start)
+		binary.LittleEndian.PutUint64(entries[index][120:], uint64(len(entry.Data)))
+	}
+	if len(entries) > 0 {
+		binary.LittleEndian.PutUint32(entries[0][116:], writer.allocate(miniStream))
+		binary.LittleEndian.PutUint64(entries[0][120:], uint64(len(miniStream)))
+	}
+
+	var directory []byte
+	for _, entry := range entries {
+		directory = append(directory, entry[:]...)
+	}
+	for len(directory)%writer.size != 0 {
+		var unused [entrySize]byte
+		binary.LittleEndian.PutUint32(unused[68:], noStream)
+		binary.LittleEndian.PutUint32(unused[72:], noStream)
+		binary.LittleEndian.PutUint32(unused[76:], noStream)
+		directory = append(directory, unused[:]...)
+	}
+	directoryStart := writer.allocate(directory)
+
+	for len(miniFAT)%perSector != 0 {
+		miniFAT = append(miniFAT, freeSector)
+	}
+	miniFATStart := writer.allocate(putUint32s(miniFAT))
+
+	//The FAT has to describe its own sectors and those of the DIFAT as well.
+	dataSectors := len(writer.fat)
+	fatCount, difatCount := (dataSectors+perSector-1)/perSector, 0
+	for {
+		difatCount = 0
+		if fatCount > headerDIFATEntries {
+			difatCount = (fatCount - headerDIFATEntries + perSector - 2) / (perSector - 1)
+		}
+		if dataSectors+fatCount+difatCount <= fatCount*perSector {
+			break
+		}
+		fatCount++
+	}
+	fatSectors := make([]uint32, fatCount)
+	for index := range fatSectors {
+		fatSectors[index] = uint32(dataSectors + index)
+		writer.fat = append(writer.fat, fatSector)
+	}
+	for index := 0; index < difatCount; index++ {
+		writer.fat = append(writer.fat, difatSector)
+	}
+	for len(writer.fat) < fatCount*perSector {
+		writer.fat = append(writer.fat, freeSector)
+	}
+
+	header := file.header
+	binary.LittleEndian.PutUint32(header[40:], 0)
+	if file.majorVersion() == 4 {
+		binary.LittleEndian.PutUint32(header[40:], uint32(len(directory)/writer.size))
+	}
+	binary.LittleEndian.PutUint32(header[44:], uint32(fatCount))
+	binary.LittleEndian.PutUint32(header[48:], directoryStart)
+	binary.LittleEndian.PutUint32(header[56:], miniStreamCutoff)
+	binary.LittleEndian.PutUint32(header[60:], miniFATStart)
+	binary.LittleEndian.PutUint32(header[64:], uint32(len(miniFAT)/perSector))
+	binary.LittleEndian.PutUint32(header[68:], endOfChain)
+	binary.LittleEndian.PutUint32(header[72:], uint32(difatCount))
+	for index := 0; index < headerDIFATEntries; index++ {
+		value := uint32(freeSector)
+		if index < fatCount {
+			value = fatSectors[index]
+		}
+		binary.LittleEndian.PutUint32(header[76+index*4:], value)
+	}
+
+	var difat []byte
+	if difatCount > 0 {
+		binary.LittleEndian.PutUint32(header[68:], uint32(dataSectors+fatCount))
+		remaining := fatSectors[headerDIFATEntries:]
+		for index := 0; index < difatCount; index++ {
+			sector := make([]uint32, perSector)
+			for slot := range sector[:perSector-1] {
+				sector[slot] = freeSector
+				if len(remaining) > 0 {
+					sector[slot], remaining = remaining[0], remaining[1:]
+				}
+			}
+			sector[perSector-1] = endOfChain
+			if index < difatCount-1 {
+				sector[perSector-1] = uint32(dataSectors + fatCount + index + 1)
+			}
+			difat = append(difat, putUint32s(sector)...)
+		}
+	}
+
+	contents := make([]byte, 0, writer.size+len(writer.body)+len(writer.fat)*4+len(difat))
+	contents = append(contents, header[:]...)
+	contents = append(contents, make([]byte, writer.size-headerSize)...)
+	contents = append(contents, writer.body...)
+	contents = append(contents, putUint32s(writer.fat)...)
+	return append(contents, difat...), nil
+}
+
+func putUint32s(values []uint32) []byte {
+	data := make([]byte, len(values)*4)
+	for index, value := range values {
+		binary.LittleEndian.PutUint32(data[index*4:], value)
+	}
+	return data
+}
@@ -0,0 +1,100 @@
+// Package download serves executables stamped with a payload built for each request.
+package download
+
+import (
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RainwayApp/metapod"
+)
+
+// Handler is an http.Handler that stamps a template with the payload returned by Payload and serves the result
+// as an attachment. Content-Length, ETag, conditional requests and byte ranges are supported, so browsers and
+// download managers can resume interrupted downloads.
+//
+// Resuming relies on the same request producing the same bytes. Payload must therefore be deterministic for a
+// given user, and the carrier key must be deterministic too: the shared RSA key only lives as long as the process,
+// so pass a persisted RSA or Ed25519 key with metapod.WithCarrierKey when resumes must survive restarts or be
+// served by several replicas. ECDSA carrier keys cannot be resumed at all.
+type Handler struct {
+	// The stub every download is stamped from.
+	Template *metapod.Template
+	// The file name suggested to the browser.
+	FileName string
+	// Builds the payload of a request.
+	Payload func(*http.Request) ([]byte, error)
+	// Options passed to Template.StampReader.
+	Options []metapod.Option
+	// Reported as Last-Modified when set.
+	ModTime time.Time
+	// Writes the response when Payload or stamping fails. A plain 500 is written when nil.
+	Error func(http.ResponseWriter, *http.Request, error)
+}
+
+// NewHandler creates a Handler serving template as fileName with the payload returned by payload.
+func NewHandler(template *metapod.Template, fileName string, payload func(*http.Request) ([]byte, error), options ...metapod.Option) *Handler {
+	return &Handler{
+		Template: template,
+		FileName: fileName,
+		Payload:  payload,
+		Options:  options,
+	}
+}
+
+func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, err := handler.Payload(r)
+	if err != nil {
+		handler.fail(w, r, err)
+		return
+	}
+
+	contents, err := handler.Template.StampReader(payload, handler.Options...)
+	if err != nil {
+		handler.fail(w, r, err)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Content-Disposition", contentDisposition(handler.FileName))
+	header.Set("ETag", `"`+hex.EncodeToString(contents.Digest())+`"`)
+	//ServeContent takes care of Content-Length, If-None-Match, If-Range and Range.
+	http.ServeContent(w, r, handler.FileName, handler.ModTime, contents)
+}
+
+// The attachment disposition suggesting fileName, which may hold any character.
+func contentDisposition(fileName string) string {
+	if disposition := mime.FormatMediaType("attachment", map[string]string{"filename": fileName}); disposition != "" {
+		return disposition
+	}
+	//Before Go 1.17 FormatMediaType refuses non-ASCII values rather than encoding them as RFC 2231 describes.
+	return "attachment; filename*=" + extendedValue(fileName)
+}
+
+// Encodes value as an RFC 5987 ext-value in UTF-8.
+func extendedValue(value string) string {
+	const attributeChars = "!#$&+-.^_`|~"
+	encoded := strings.Builder{}
+	encoded.WriteString("utf-8''")
+	for _, octet := range []byte(value) {
+		if 'a' <= octet && octet <= 'z' || 'A' <= octet && octet <= 'Z' || '0' <= octet && octet <= '9' ||
+			strings.IndexByte(attributeChars, octet) >= 0 {
+			encoded.WriteByte(octet)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", octet)
+		}
+	}
+	return encoded.String()
+}
+
+func (handler *Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if handler.Error != nil {
+		handler.Error(w, r, err)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
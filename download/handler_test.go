@@ -0,0 +1,95 @@
+package download
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RainwayApp/metapod"
+)
+
+func newTestHandler(t testing.TB, fileName string) (*Handler, []byte) {
+	stub, err := ioutil.ReadFile("../testdata/stub.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := metapod.LoadTemplate(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := func(r *http.Request) ([]byte, error) {
+		return []byte("user=" + r.URL.Query().Get("user")), nil
+	}
+	//The attribute embedding needs no carrier key, so every request for a user gets the same bytes.
+	handler := NewHandler(template, fileName, payload, metapod.WithEmbedding(metapod.AttributeEmbedding))
+	contents, err := template.Stamp([]byte("user=1"), handler.Options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler, contents
+}
+
+func serve(handler http.Handler, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/download?user=1", nil)
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestHandler(t *testing.T) {
+	handler, contents := newTestHandler(t, "setup.exe")
+	etag := serve(handler, nil).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		body    []byte
+	}{
+		{"full", nil, http.StatusOK, contents},
+		{"range", map[string]string{"Range": "bytes=100-199"}, http.StatusPartialContent, contents[100:200]},
+		{"matching If-Range", map[string]string{"Range": "bytes=100-", "If-Range": etag}, http.StatusPartialContent, contents[100:]},
+		{"stale If-Range", map[string]string{"Range": "bytes=100-", "If-Range": `"stale"`}, http.StatusOK, contents},
+		{"matching If-None-Match", map[string]string{"If-None-Match": etag}, http.StatusNotModified, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := serve(handler, test.headers)
+			if response.Code != test.status {
+				t.Fatalf("status %d, want %d", response.Code, test.status)
+			}
+			if !bytes.Equal(response.Body.Bytes(), test.body) {
+				t.Errorf("%d bytes, want %d", response.Body.Len(), len(test.body))
+			}
+			if got := response.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag %s, want %s", got, etag)
+			}
+		})
+	}
+}
+
+func TestHandlerContentDisposition(t *testing.T) {
+	for _, fileName := range []string{"setup.exe", "Rainway Setup (1).exe", "Установка Rainway – 雨.exe"} {
+		t.Run(fileName, func(t *testing.T) {
+			handler, _ := newTestHandler(t, fileName)
+			disposition, params, err := mime.ParseMediaType(serve(handler, nil).Header().Get("Content-Disposition"))
+			if err != nil || disposition != "attachment" || params["filename"] != fileName {
+				t.Errorf("ParseMediaType = %q, %q, %v", disposition, params, err)
+			}
+			//The encoding used when FormatMediaType cannot encode the name itself.
+			_, params, err = mime.ParseMediaType("attachment; filename*=" + extendedValue(fileName))
+			if err != nil || params["filename"] != fileName {
+				t.Errorf("extendedValue: %q, %v", params, err)
+			}
+		})
+	}
+}
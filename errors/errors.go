@@ -2,8 +2,126 @@ package errors
 
 func errorText(code int) string {
 	switch code {
+	case 1145:
+		return "Mach-O binaries cannot carry an appended tag"
+	case 1144:
+		return "code signature has no room left for the carrier, the binary must be signed with space reserved for it"
+	case 1143:
+		return "code signature is malformed"
+	case 1142:
+		return "code signature has no CMS signature, ad-hoc signed binaries cannot carry a payload"
+	case 1141:
+		return "Mach-O binary has no code signature"
+	case 1140:
+		return "input file is not a Mach-O binary"
+	case 1131:
+		return "MSI packages cannot carry an appended tag"
+	case 1130:
+		return "MSI package has no digital signature"
+	case 1126:
+		return "stream is too large for a version 3 compound file"
+	case 1125:
+		return "compound file FAT is malformed"
+	case 1124:
+		return "compound file sector chain is malformed"
+	case 1123:
+		return "compound file stream is shorter than its directory entry says"
+	case 1122:
+		return "compound file directory is malformed"
+	case 1121:
+		return "compound file version is not supported"
+	case 1120:
+		return "input file is not a compound file"
+	case 1117:
+		return "payload is too large for an appended tag"
+	case 1116:
+		return "only a plain payload without compression, content type, entries, sealing or signature can be stored in an appended tag"
+	case 1115:
+		return "unknown payload embedding"
+	case 1114:
+		return "SignerInfo cannot be re-encoded exactly, so it cannot carry an attribute"
+	case 1113:
+		return "unknown carrier placement"
+	case 1112:
+		return "signature has no nested signature to carry the payload"
+	case 1111:
+		return "nested signature is malformed"
+	case 1110:
+		return "invalid template handle"
+	case 1103:
+		return "content type must be a UTF-8 string of at most 255 bytes"
+	case 1102:
+		return "payload envelope checksum does not match, the payload is corrupt"
+	case 1101:
+		return "payload envelope version or flags are not supported"
+	case 1100:
+		return "payload envelope is malformed"
+	case 1098:
+		return "compressed payload is larger than the decompression limit"
+	case 1096:
+		return "compressed payload is malformed"
+	case 1095:
+		return "failed to compress the payload"
+	case 1094:
+		return "payload is signed, its signing key is needed to change it"
+	case 1093:
+		return "payload signature is invalid"
+	case 1092:
+		return "payload signature is malformed"
+	case 1091:
+		return "payload is not signed"
+	case 1090:
+		return "payload signing key is not an Ed25519 key"
+	case 1087:
+		return "sealing key IDs must be UTF-8 strings"
+	case 1086:
+		return "failed to generate a nonce"
+	case 1085:
+		return "payload is not sealed"
+	case 1084:
+		return "payload is sealed and needs a key to be opened"
+	case 1083:
+		return "sealed payload is malformed"
+	case 1082:
+		return "sealed payload failed to decrypt or authenticate"
+	case 1081:
+		return "no sealing key matches the key ID of the payload"
+	case 1080:
+		return "sealing key must be 32 bytes (AES-256)"
+	case 1071:
+		return "unable to build a trusted certificate chain for the signer"
+	case 1070:
+		return "image digest does not match the digest that was signed"
+	case 1069:
+		return "signature over the authenticated attributes is invalid"
+	case 1068:
+		return "messageDigest attribute does not match the signed content"
+	case 1067:
+		return "contentType attribute is not SpcIndirectDataContent"
+	case 1066:
+		return "authenticated attributes are malformed"
+	case 1065:
+		return "signer certificate is missing from the signature"
+	case 1064:
+		return "signature has no signer"
+	case 1063:
+		return "unable to parse SignerInfos"
+	case 1062:
+		return "unsupported digest algorithm"
+	case 1061:
+		return "unable to parse SpcIndirectDataContent"
+	case 1060:
+		return "signature does not contain SpcIndirectDataContent"
+	case 1053:
+		return "carrier certificate origin is malformed"
+	case 1052:
+		return "entry keys must be non-empty UTF-8 strings"
+	case 1051:
+		return "payload entries are malformed"
 	case 1050:
 		return "unable to locate payload within input file"
+	case 1044:
+		return "unknown carrier key algorithm"
 	case 1043:
 		return "the input file contains no certificates"
 	case 1042:
@@ -11,7 +129,15 @@ func errorText(code int) string {
 	case 1041:
 		return "failed to create X509Certificate from provided templates"
 	case 1040:
-		return "failed to generate RSA keypair"
+		return "failed to generate carrier keypair"
+	case 1037:
+		return "attribute certificate table holds no PKCS#7 signed data entry"
+	case 1036:
+		return "the whole portable executable is required but only its headers were read"
+	case 1035:
+		return "unable to write the output file"
+	case 1034:
+		return "unable to read from the input file"
 	case 1033:
 		return "internal error calculating certificate data offset"
 	case 1032:
@@ -23,9 +149,9 @@ func errorText(code int) string {
 	case 1029:
 		return "unable to read IMAGE_SECTION_HEADER. EOF?"
 	case 1028:
-		return "unable to read IMAGE_OPTIONAL_HEADER32"
+		return "unable to read IMAGE_OPTIONAL_HEADER"
 	case 1027:
-		return "input file must be 32-bit. 64-bit support planned"
+		return "input file is neither PE32 nor PE32+ (unknown optional header magic)"
 	case 1026:
 		return "input file cannot be a DLL"
 	case 1025:
@@ -40,6 +166,12 @@ func errorText(code int) string {
 		return "reached EOF searching for the portable executable signature"
 	case 1020:
 		return "the length of the input file is less than the PE offset"
+	case 1012:
+		return "PKCS#7 SignedData does not encode back to the same bytes"
+	case 1011:
+		return "unable to encode PKCS#7 SignedData"
+	case 1010:
+		return "unable to parse PKCS#7 SignedData"
 	case 1004:
 		return "incorrect number of bytes reading ASN.1 length"
 	case 1005:
@@ -52,6 +184,8 @@ func errorText(code int) string {
 		return "multiple attribute certificates found. unable to proceed."
 	case 1009:
 		return "attribute certificate seems malformed"
+	case 1000:
+		return "unexpected error"
 	}
 	return "unknown error code."
 }
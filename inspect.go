@@ -0,0 +1,240 @@
+package metapod
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/RainwayApp/metapod/authenticode"
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+// Inspection is a report on a portable executable and its signature, as far as they could be decoded.
+// When the file cannot be stamped or opened, ErrorCode and Error say why and every field that could still
+// be read is filled in.
+type Inspection struct {
+	Size int `json:"size"`
+	// The IMAGE_FILE_MACHINE name, such as "I386" or "AMD64", or its value in hex when unknown.
+	Machine string `json:"machine"`
+	// "PE32" or "PE32+".
+	Format string `json:"format"`
+	// The IMAGE_SUBSYSTEM name, such as "WINDOWS_GUI", or its value when unknown.
+	Subsystem     string    `json:"subsystem"`
+	CheckSum      uint32    `json:"checkSum"`
+	CheckSumValid bool      `json:"checkSumValid"`
+	Sections      []Section `json:"sections"`
+	// nil when the executable has no certificate table.
+	CertificateTable *CertificateTable `json:"certificateTable,omitempty"`
+	// nil when the signer could not be found.
+	Signer *Signer `json:"signer,omitempty"`
+	// The number of certificates embedded in the signature, carrier certificates included.
+	Certificates int `json:"certificates"`
+	// The number of signatures nested in the outer one, as dual signed executables have.
+	NestedSignatures int  `json:"nestedSignatures"`
+	PayloadPresent   bool `json:"payloadPresent"`
+	PayloadSize      int  `json:"payloadSize"`
+	PayloadSealed    bool `json:"payloadSealed"`
+	Entries          int  `json:"entries"`
+	// The length of the payload of the appended tag, as its header gives it, 0 when there is no tag.
+	// The tag magic, the length itself and the padding after the tag are not counted.
+	TagPayloadLength int `json:"tagPayloadLength"`
+	// The MetaPod error the file fails with, 0 when there is none.
+	ErrorCode int    `json:"errorCode,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Section is an entry of the section table.
+type Section struct {
+	Name             string `json:"name"`
+	VirtualAddress   uint32 `json:"virtualAddress"`
+	VirtualSize      uint32 `json:"virtualSize"`
+	PointerToRawData uint32 `json:"pointerToRawData"`
+	SizeOfRawData    uint32 `json:"sizeOfRawData"`
+	Characteristics  uint32 `json:"characteristics"`
+}
+
+// CertificateTable is the attribute certificate table as the data directory describes it, along with its entries.
+type CertificateTable struct {
+	Offset uint32 `json:"offset"`
+	Size   uint32 `json:"size"`
+	// nil when the table could not be split into entries.
+	Entries []WinCertificate `json:"entries,omitempty"`
+}
+
+// WinCertificate is the header of a WIN_CERTIFICATE entry of the attribute certificate table.
+type WinCertificate struct {
+	// The offset of the entry from the start of the file.
+	Offset   int    `json:"offset"`
+	Length   uint32 `json:"length"`
+	Revision uint16 `json:"revision"`
+	// 1 for X.509, 2 for PKCS#7 signed data, 4 for a terminal server protocol stack certificate.
+	Type uint16 `json:"type"`
+}
+
+// Signer identifies the certificate the executable was signed with.
+type Signer struct {
+	Subject string `json:"subject"`
+	Issuer  string `json:"issuer"`
+	// The serial number in hexadecimal.
+	SerialNumber string `json:"serialNumber"`
+}
+
+// https://docs.microsoft.com/en-us/windows/win32/debug/pe-format#machine-types
+var machineNames = map[uint16]string{
+	0x014c: "I386",
+	0x0166: "R4000",
+	0x01c0: "ARM",
+	0x01c2: "THUMB",
+	0x01c4: "ARMNT",
+	0x0200: "IA64",
+	0x8664: "AMD64",
+	0xaa64: "ARM64",
+}
+
+// https://docs.microsoft.com/en-us/windows/win32/debug/pe-format#windows-subsystem
+var subsystemNames = map[uint16]string{
+	1:  "NATIVE",
+	2:  "WINDOWS_GUI",
+	3:  "WINDOWS_CUI",
+	5:  "OS2_CUI",
+	7:  "POSIX_CUI",
+	8:  "NATIVE_WINDOWS",
+	9:  "WINDOWS_CE_GUI",
+	10: "EFI_APPLICATION",
+	11: "EFI_BOOT_SERVICE_DRIVER",
+	12: "EFI_RUNTIME_DRIVER",
+	13: "EFI_ROM",
+	14: "XBOX",
+	16: "WINDOWS_BOOT_APPLICATION",
+}
+
+// Inspect reports on the layout of a portable executable and its signature.
+// Only a file whose headers cannot be read at all returns an error; anything that goes wrong past the headers
+// is recorded in the report instead.
+func Inspect(peFile []byte) (*Inspection, error) {
+	headers, err := windows.ReadHeaders(peFile)
+	if err != nil {
+		return nil, err
+	}
+
+	inspection := &Inspection{
+		Size:      len(peFile),
+		Machine:   machineNames[headers.FileHeader.Machine],
+		Format:    "PE32",
+		Subsystem: subsystemNames[headers.Subsystem],
+		CheckSum:  headers.CheckSum,
+		Sections:  make([]Section, 0, len(headers.Sections)),
+	}
+	if inspection.Machine == "" {
+		inspection.Machine = fmt.Sprintf("0x%04x", headers.FileHeader.Machine)
+	}
+	if headers.Magic == 0x20b {
+		inspection.Format = "PE32+"
+	}
+	if inspection.Subsystem == "" {
+		inspection.Subsystem = fmt.Sprint(headers.Subsystem)
+	}
+	if inspection.CheckSumValid, err = windows.ValidateCheckSum(peFile); err != nil {
+		return nil, err
+	}
+	for _, section := range headers.Sections {
+		inspection.Sections = append(inspection.Sections, Section{
+			Name:             string(bytes.TrimRight(section.Name[:], "\x00")),
+			VirtualAddress:   section.VirtualAddress,
+			VirtualSize:      section.VirtualSize,
+			PointerToRawData: section.PointerToRawData,
+			SizeOfRawData:    section.SizeOfRawData,
+			Characteristics:  uint32(section.Characteristics),
+		})
+	}
+
+	if directory := headers.CertificateTable; directory.VirtualAddress != 0 {
+		table := &CertificateTable{Offset: directory.VirtualAddress, Size: directory.Size}
+		if end := uint64(directory.VirtualAddress) + uint64(directory.Size); end <= uint64(len(peFile)) {
+			offset := int(directory.VirtualAddress)
+			entries, _ := windows.ParseAttributeCertificates(peFile[offset:end], offset)
+			for _, entry := range entries {
+				table.Entries = append(table.Entries, WinCertificate{
+					Offset:   entry.Offset,
+					Length:   entry.Length,
+					Revision: entry.Revision,
+					Type:     entry.Type,
+				})
+			}
+		}
+		inspection.CertificateTable = table
+	}
+
+	inspection.fail(inspection.inspectSignature(peFile))
+	return inspection, nil
+}
+
+// Fills in everything that depends on the signature. The signer is resolved before the payload is decoded, so that
+// it is reported even for a file whose payload or entries are damaged.
+func (inspection *Inspection) inspectSignature(peFile []byte) error {
+	portableExecutable, err := windows.GetPortableExecutable(peFile)
+	if err != nil {
+		return err
+	}
+	signedData := portableExecutable.X509Certificate
+	inspection.Certificates = len(signedData.PKCS7.Certificates)
+	nested, err := windows.NestedSignatures(signedData)
+	if err != nil {
+		return err
+	}
+	inspection.NestedSignatures = len(nested)
+	signerError := inspection.inspectSigner(signedData)
+
+	targetExecutable := windows.TargetExecutable{PortableExecutable: *portableExecutable}
+	inspection.TagPayloadLength = targetExecutable.TagPayloadLength()
+	envelope, err := targetExecutable.GetEnvelope()
+	if code, ok := err.(errors.MetapodError); ok && code.ErrCode() == 1084 {
+		inspection.PayloadPresent, inspection.PayloadSealed = true, true
+	} else if err != nil {
+		return err
+	} else if envelope != nil {
+		inspection.PayloadPresent, inspection.PayloadSize = true, len(envelope.Payload)
+	}
+	entries, err := targetExecutable.GetEntries()
+	if err != nil {
+		return err
+	}
+	inspection.Entries = len(entries)
+	return signerError
+}
+
+// Finds the certificate of the first signer among those embedded in the signature.
+func (inspection *Inspection) inspectSigner(signedData *structs.X509Certificate) error {
+	signerInfos, err := authenticode.SignerInfos(signedData)
+	if err != nil {
+		return err
+	}
+	if len(signerInfos) == 0 {
+		return errors.NewError(1064)
+	}
+	issuerAndSerial := signerInfos[0].IssuerAndSerialNumber
+	for _, certificate := range authenticode.Certificates(signedData) {
+		if bytes.Equal(certificate.RawIssuer, issuerAndSerial.Issuer.FullBytes) &&
+			certificate.SerialNumber.Cmp(issuerAndSerial.SerialNumber) == 0 {
+			inspection.Signer = &Signer{
+				Subject:      certificate.Subject.String(),
+				Issuer:       certificate.Issuer.String(),
+				SerialNumber: certificate.SerialNumber.Text(16),
+			}
+			return nil
+		}
+	}
+	return errors.NewError(1065)
+}
+
+// Records the error the file fails with.
+func (inspection *Inspection) fail(err error) {
+	if err == nil {
+		return
+	}
+	inspection.Error = err.Error()
+	if code, ok := err.(errors.MetapodError); ok {
+		inspection.ErrorCode = code.ErrCode()
+	}
+}
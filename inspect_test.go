@@ -0,0 +1,51 @@
+package metapod
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	stub := readStub(t)
+	stamp := func(payload string, options ...Option) []byte {
+		stamped, err := Create(stub, []byte(payload), options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stamped
+	}
+	//Flipping a bit of the payload leaves the signature parseable but fails the CRC of the envelope.
+	damaged := stamp("damaged payload")
+	damaged[bytes.Index(damaged, []byte("damaged payload"))] ^= 1
+
+	tests := []struct {
+		name             string
+		file             []byte
+		payloadSize      int
+		tagPayloadLength int
+		errorCode        int
+	}{
+		{"stub", stub, 0, 0, 0},
+		{"certificate", stamp("payload"), 7, 0, 0},
+		{"tag", stamp("tagged payload", WithEmbedding(TagEmbedding)), 14, 14, 0},
+		{"damaged payload", damaged, 0, 0, 1102},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inspection, err := Inspect(test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inspection.Signer == nil {
+				t.Error("Signer = nil")
+			}
+			if inspection.PayloadSize != test.payloadSize || inspection.TagPayloadLength != test.tagPayloadLength {
+				t.Errorf("PayloadSize = %d, TagPayloadLength = %d, want %d, %d",
+					inspection.PayloadSize, inspection.TagPayloadLength, test.payloadSize, test.tagPayloadLength)
+			}
+			if inspection.ErrorCode != test.errorCode {
+				t.Errorf("ErrorCode = %d (%s), want %d", inspection.ErrorCode, inspection.Error, test.errorCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package macho
+
+import (
+	"bytes"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+//codesign writes the CMS signature in BER, with indefinite lengths, where encoding/asn1 only reads DER.
+//Nested deeper than this, an element is taken to be malicious rather than a signature.
+const maxBERDepth = 64
+
+//Converts the BER element at the start of data to DER, returning it along with whatever follows it.
+//Indefinite lengths become definite and constructed OCTET STRINGs are joined into primitive ones.
+//Anything already in DER comes out unchanged.
+func berToDER(data []byte) (der, rest []byte, err error) {
+	return convertBER(data, 0)
+}
+
+func convertBER(data []byte, depth int) (der, rest []byte, err error) {
+	if depth > maxBERDepth || len(data) < 2 {
+		return nil, nil, errors.NewError(1010)
+	}
+
+	//The identifier octets, with any high tag number.
+	identifierLength := 1
+	if data[0]&0x1f == 0x1f {
+		for identifierLength < len(data) && data[identifierLength]&0x80 != 0 {
+			identifierLength++
+		}
+		identifierLength++
+	}
+	if identifierLength >= len(data) {
+		return nil, nil, errors.NewError(1010)
+	}
+	identifier, constructed := data[:identifierLength], data[0]&0x20 != 0
+	data = data[identifierLength:]
+
+	indefinite, length := data[0] == 0x80, 0
+	switch {
+	case indefinite:
+		if !constructed {
+			return nil, nil, errors.NewError(1010)
+		}
+		data = data[1:]
+	case data[0] < 0x80:
+		length, data = int(data[0]), data[1:]
+	default:
+		count := int(data[0] & 0x7f)
+		if count > 4 || count >= len(data) {
+			return nil, nil, errors.NewError(1010)
+		}
+		for _, octet := range data[1 : 1+count] {
+			length = length<<8 | int(octet)
+		}
+		data = data[1+count:]
+	}
+	if !indefinite && (length < 0 || length > len(data)) {
+		return nil, nil, errors.NewError(1010)
+	}
+
+	if !constructed {
+		return element(identifier, data[:length]), data[length:], nil
+	}
+
+	contents, remaining := data, []byte(nil)
+	if !indefinite {
+		contents, remaining = data[:length], data[length:]
+	}
+	//A constructed OCTET STRING is made of OCTET STRINGs, which DER wants joined.
+	octetString := identifier[0] == 0x24
+	var content bytes.Buffer
+	for {
+		if indefinite {
+			if len(contents) < 2 {
+				return nil, nil, errors.NewError(1010)
+			}
+			if contents[0] == 0 && contents[1] == 0 {
+				remaining = contents[2:]
+				break
+			}
+		} else if len(contents) == 0 {
+			break
+		}
+		child, next, err := convertBER(contents, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if octetString {
+			if child[0] != 0x04 {
+				return nil, nil, errors.NewError(1010)
+			}
+			child = child[1+lengthOctets(child[1:]):]
+		}
+		content.Write(child)
+		contents = next
+	}
+
+	if octetString {
+		return element([]byte{0x04}, content.Bytes()), remaining, nil
+	}
+	return element(identifier, content.Bytes()), remaining, nil
+}
+
+//Encodes an element with a definite length in its shortest form.
+func element(identifier, content []byte) []byte {
+	var length []byte
+	if len(content) < 0x80 {
+		length = []byte{byte(len(content))}
+	} else {
+		for size := len(content); size > 0; size >>= 8 {
+			length = append([]byte{byte(size)}, length...)
+		}
+		length = append([]byte{0x80 | byte(len(length))}, length...)
+	}
+	der := make([]byte, 0, len(identifier)+len(length)+len(content))
+	der = append(der, identifier...)
+	der = append(der, length...)
+	return append(der, content...)
+}
+
+//The number of octets of a definite length encoded at the start of data.
+func lengthOctets(data []byte) int {
+	if data[0] < 0x80 {
+		return 1
+	}
+	return 1 + int(data[0]&0x7f)
+}
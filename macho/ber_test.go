@@ -0,0 +1,59 @@
+package macho
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBERToDER(t *testing.T) {
+	long := bytes.Repeat([]byte{0xab}, 200)
+	tests := []struct {
+		name string
+		ber  []byte
+		der  []byte
+	}{
+		{"DER", []byte{0x30, 0x03, 0x02, 0x01, 0x05}, []byte{0x30, 0x03, 0x02, 0x01, 0x05}},
+		{"indefinite", []byte{0x30, 0x80, 0x02, 0x01, 0x05, 0, 0}, []byte{0x30, 0x03, 0x02, 0x01, 0x05}},
+		{"nested indefinite", []byte{0x30, 0x80, 0xa0, 0x80, 0x05, 0x00, 0, 0, 0, 0}, []byte{0x30, 0x04, 0xa0, 0x02, 0x05, 0x00}},
+		{"definite around indefinite", []byte{0x30, 0x06, 0x31, 0x80, 0x05, 0x00, 0, 0}, []byte{0x30, 0x04, 0x31, 0x02, 0x05, 0x00}},
+		{"long form", append([]byte{0x04, 0x82, 0x00, 0xc8}, long...), append([]byte{0x04, 0x81, 0xc8}, long...)},
+		{"constructed OCTET STRING", []byte{0x24, 0x80, 0x04, 0x01, 'a', 0x24, 0x03, 0x04, 0x01, 'b', 0, 0},
+			[]byte{0x04, 0x02, 'a', 'b'}},
+		{"high tag number", []byte{0xbf, 0x81, 0x00, 0x80, 0x05, 0x00, 0, 0}, []byte{0xbf, 0x81, 0x00, 0x02, 0x05, 0x00}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			der, rest, err := berToDER(append(append([]byte{}, test.ber...), 0xff))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(der, test.der) || !bytes.Equal(rest, []byte{0xff}) {
+				t.Errorf("berToDER = %x, %x, want %x, ff", der, rest, test.der)
+			}
+		})
+	}
+}
+
+func TestBERToDERErrors(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x30, 0x80}, maxBERDepth+2)
+	tests := []struct {
+		name string
+		ber  []byte
+	}{
+		{"empty", nil},
+		{"no length", []byte{0x30}},
+		{"length past the end", []byte{0x04, 0x05, 0x00}},
+		{"no end of contents", []byte{0x30, 0x80, 0x05, 0x00}},
+		{"indefinite primitive", []byte{0x04, 0x80, 0x00, 0x00}},
+		{"length too long", []byte{0x04, 0x85, 1, 0, 0, 0, 0}},
+		{"constructed OCTET STRING of another type", []byte{0x24, 0x80, 0x02, 0x01, 0x05, 0, 0}},
+		{"too deep", deep},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := berToDER(test.ber); errorCode(err) != 1010 {
+				t.Errorf("berToDER: %v, want error 1010", err)
+			}
+		})
+	}
+}
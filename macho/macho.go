@@ -0,0 +1,348 @@
+//Package macho stamps signed macOS binaries, thin or fat. The code signature of a Mach-O binary is a SuperBlob that
+//the LC_CODE_SIGNATURE load command points to, and its CMS blob holds a PKCS#7 SignedData whose certificate set the
+//code directory hashes do not cover, so it can carry a payload the same way the certificate table of a portable
+//executable does.
+//
+//The load commands, LC_CODE_SIGNATURE and __LINKEDIT among them, are hashed by the code directory, so the room
+//they give the signature cannot grow without breaking it. The carrier has to fit in the padding codesign leaves
+//after the SuperBlob, which means the binary must be signed with space to spare, for instance with the
+//--signature-size option of codesign. Stamping fails with error 1144 otherwise.
+//https://opensource.apple.com/source/Security/Security-55471/libsecurity_codesigning/lib/cscdefs.h
+package macho
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+const (
+	magic32         = 0xfeedface
+	magic64         = 0xfeedfacf
+	fatMagic        = 0xcafebabe
+	fatMagic64      = 0xcafebabf
+	lcCodeSignature = 0x1d
+	//A fat binary lists fewer architectures than this.
+	maxArchitectures = 20
+)
+
+//Code signature blobs, which are always big endian.
+const (
+	superBlobMagic   = 0xfade0cc0
+	blobWrapperMagic = 0xfade0b01
+	cmsSlot          = 0x10000
+)
+
+//The code signature of a single architecture.
+type signature struct {
+	//Where the SuperBlob starts in the file and how much room LC_CODE_SIGNATURE reserves for it.
+	offset, size int
+	//The SuperBlob, up to the length it records.
+	superBlob []byte
+	//Where the CMS blob starts in the SuperBlob and its length, header included.
+	cmsOffset, cmsLength int
+	//Anything within the CMS blob after the SignedData.
+	trailer []byte
+	//The SignedData in DER. codesign writes it in BER, so it need not be the bytes stored in the CMS blob.
+	der        []byte
+	signedData *structs.X509Certificate
+	//The SignedData without any carrier.
+	stub structs.X509Certificate
+}
+
+//Binary is a signed Mach-O binary reduced to what stamping needs. Like windows.Template it is never modified once
+//created, so one Binary can stamp from many goroutines at once.
+type Binary struct {
+	contents []byte
+	//One signature per architecture, in the order of the fat header.
+	signatures []*signature
+}
+
+//IsBinary tells whether contents start with the magic of a Mach-O or a fat binary.
+func IsBinary(contents []byte) bool {
+	if len(contents) < 4 {
+		return false
+	}
+	switch binary.BigEndian.Uint32(contents) {
+	case magic32, magic64:
+		return true
+	case fatMagic, fatMagic64:
+		//Java class files start with the same magic, followed by a version that is never below 20.
+		//file(1) tells them apart the same way.
+		if len(contents) < 8 {
+			return false
+		}
+		count := binary.BigEndian.Uint32(contents[4:])
+		return count > 0 && count < maxArchitectures
+	}
+	switch binary.LittleEndian.Uint32(contents) {
+	case magic32, magic64:
+		return true
+	}
+	return false
+}
+
+//ReadBinary parses a signed Mach-O binary. Every architecture of a fat binary must be signed.
+//The contents are referenced rather than copied and must not change while the Binary is in use.
+func ReadBinary(contents []byte) (*Binary, error) {
+	slices, err := architectures(contents)
+	if err != nil {
+		return nil, err
+	}
+	machoBinary := &Binary{contents: contents}
+	for _, slice := range slices {
+		offset, size, err := codeSignature(contents[slice[0]:slice[1]])
+		if err != nil {
+			return nil, err
+		}
+		signature, err := readSignature(contents, slice[0]+offset, size)
+		if err != nil {
+			return nil, err
+		}
+		machoBinary.signatures = append(machoBinary.signatures, signature)
+	}
+	return machoBinary, nil
+}
+
+//Returns the start and end of every architecture in the file, the whole file when it is not fat.
+func architectures(contents []byte) ([][2]int, error) {
+	if !IsBinary(contents) {
+		return nil, errors.NewError(1140)
+	}
+	magic := binary.BigEndian.Uint32(contents)
+	if magic != fatMagic && magic != fatMagic64 {
+		return [][2]int{{0, len(contents)}}, nil
+	}
+
+	entrySize := 20
+	if magic == fatMagic64 {
+		entrySize = 32
+	}
+	count := int(binary.BigEndian.Uint32(contents[4:]))
+	if uint64(8+count*entrySize) > uint64(len(contents)) {
+		return nil, errors.NewError(1140)
+	}
+	slices := make([][2]int, count)
+	for index := range slices {
+		entry := contents[8+index*entrySize:]
+		var offset, size uint64
+		if magic == fatMagic64 {
+			offset, size = binary.BigEndian.Uint64(entry[8:]), binary.BigEndian.Uint64(entry[16:])
+		} else {
+			offset, size = uint64(binary.BigEndian.Uint32(entry[8:])), uint64(binary.BigEndian.Uint32(entry[12:]))
+		}
+		if offset+size < offset || offset+size > uint64(len(contents)) {
+			return nil, errors.NewError(1140)
+		}
+		slices[index] = [2]int{int(offset), int(offset + size)}
+	}
+	return slices, nil
+}
+
+//Finds the code signature of a thin binary through its LC_CODE_SIGNATURE load command.
+func codeSignature(slice []byte) (offset, size int, err error) {
+	if len(slice) < 28 {
+		return 0, 0, errors.NewError(1140)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	magic := order.Uint32(slice)
+	if magic != magic32 && magic != magic64 {
+		order = binary.BigEndian
+		magic = order.Uint32(slice)
+		if magic != magic32 && magic != magic64 {
+			return 0, 0, errors.NewError(1140)
+		}
+	}
+	headerSize := 28
+	if magic == magic64 {
+		headerSize = 32
+	}
+	commands, commandsSize := int(order.Uint32(slice[16:])), uint64(order.Uint32(slice[20:]))
+	if uint64(headerSize)+commandsSize > uint64(len(slice)) {
+		return 0, 0, errors.NewError(1140)
+	}
+
+	loadCommands := slice[headerSize : headerSize+int(commandsSize)]
+	for index := 0; index < commands; index++ {
+		if len(loadCommands) < 8 {
+			return 0, 0, errors.NewError(1140)
+		}
+		command, commandSize := order.Uint32(loadCommands), order.Uint32(loadCommands[4:])
+		if commandSize < 8 || uint64(commandSize) > uint64(len(loadCommands)) {
+			return 0, 0, errors.NewError(1140)
+		}
+		if command == lcCodeSignature {
+			if commandSize < 16 {
+				return 0, 0, errors.NewError(1140)
+			}
+			dataOffset, dataSize := uint64(order.Uint32(loadCommands[8:])), uint64(order.Uint32(loadCommands[12:]))
+			if dataOffset+dataSize > uint64(len(slice)) {
+				return 0, 0, errors.NewError(1143)
+			}
+			return int(dataOffset), int(dataSize), nil
+		}
+		loadCommands = loadCommands[commandSize:]
+	}
+	return 0, 0, errors.NewError(1141)
+}
+
+//Decodes the SuperBlob at offset and the SignedData of its CMS blob.
+func readSignature(contents []byte, offset, size int) (*signature, error) {
+	region := contents[offset : offset+size]
+	if len(region) < 12 || binary.BigEndian.Uint32(region) != superBlobMagic {
+		return nil, errors.NewError(1143)
+	}
+	length, count := uint64(binary.BigEndian.Uint32(region[4:])), uint64(binary.BigEndian.Uint32(region[8:]))
+	if length > uint64(len(region)) || 12+count*8 > length {
+		return nil, errors.NewError(1143)
+	}
+	superBlob := region[:length]
+
+	sig := &signature{offset: offset, size: size, superBlob: superBlob, cmsOffset: -1}
+	for index := 0; index < int(count); index++ {
+		entry := superBlob[12+index*8:]
+		if binary.BigEndian.Uint32(entry) != cmsSlot {
+			continue
+		}
+		blobOffset := uint64(binary.BigEndian.Uint32(entry[4:]))
+		if blobOffset+8 > length || binary.BigEndian.Uint32(superBlob[blobOffset:]) != blobWrapperMagic {
+			return nil, errors.NewError(1143)
+		}
+		blobLength := uint64(binary.BigEndian.Uint32(superBlob[blobOffset+4:]))
+		if blobLength < 8 || blobOffset+blobLength > length {
+			return nil, errors.NewError(1143)
+		}
+		sig.cmsOffset, sig.cmsLength = int(blobOffset), int(blobLength)
+	}
+	//Ad-hoc signatures have no CMS blob, or an empty one.
+	if sig.cmsOffset < 0 || sig.cmsLength == 8 {
+		return nil, errors.NewError(1142)
+	}
+
+	der, rest, err := berToDER(superBlob[sig.cmsOffset+8 : sig.cmsOffset+sig.cmsLength])
+	if err != nil {
+		return nil, err
+	}
+	sig.der, sig.trailer = der, rest
+	if sig.signedData, err = windows.ParseSignedData(der); err != nil {
+		return nil, err
+	}
+	if sig.stub, err = windows.WithoutCarriers(*sig.signedData); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+//Rebuilds the SuperBlob with signedData in its CMS blob. Every other blob is kept as it is and moved along.
+func (sig *signature) withSignedData(signedData structs.X509Certificate) ([]byte, error) {
+	der, err := asn1.Marshal(signedData)
+	if err != nil {
+		return nil, errors.NewError(1042)
+	}
+	//Unchanged, the SignedData is left in whatever encoding codesign gave it.
+	if bytes.Equal(der, sig.der) {
+		return sig.superBlob, nil
+	}
+	blob := make([]byte, 8, 8+len(der)+len(sig.trailer))
+	blob = append(append(blob, der...), sig.trailer...)
+	binary.BigEndian.PutUint32(blob, blobWrapperMagic)
+	binary.BigEndian.PutUint32(blob[4:], uint32(len(blob)))
+
+	end := sig.cmsOffset + sig.cmsLength
+	superBlob := make([]byte, 0, len(sig.superBlob)-sig.cmsLength+len(blob))
+	superBlob = append(superBlob, sig.superBlob[:sig.cmsOffset]...)
+	superBlob = append(superBlob, blob...)
+	superBlob = append(superBlob, sig.superBlob[end:]...)
+
+	//The load commands are covered by the code directory, so the room they reserve for the signature cannot grow.
+	if len(superBlob) > sig.size {
+		return nil, errors.NewError(1144)
+	}
+	binary.BigEndian.PutUint32(superBlob[4:], uint32(len(superBlob)))
+	delta := len(blob) - sig.cmsLength
+	count := int(binary.BigEndian.Uint32(superBlob[8:]))
+	for index := 0; index < count; index++ {
+		entry := superBlob[12+index*8+4:]
+		if blobOffset := int(binary.BigEndian.Uint32(entry)); blobOffset >= end {
+			binary.BigEndian.PutUint32(entry, uint32(blobOffset+delta))
+		}
+	}
+	return superBlob, nil
+}
+
+//Target wraps the signature carrying a payload, or the first one when none does, so that the carrier can be read
+//with the methods of windows.TargetExecutable. Only the signature is filled in; methods that need the layout of a
+//portable executable must not be called on it.
+func (machoBinary *Binary) Target() *windows.TargetExecutable {
+	var first *windows.TargetExecutable
+	for _, sig := range machoBinary.signatures {
+		target := &windows.TargetExecutable{
+			PortableExecutable: structs.PortableExecutable{
+				Contents:        machoBinary.contents,
+				Size:            int64(len(machoBinary.contents)),
+				X509Certificate: sig.signedData,
+			},
+		}
+		if target.CarrierExtensions() != nil {
+			return target
+		}
+		if first == nil {
+			first = target
+		}
+	}
+	return first
+}
+
+//Stamp creates a new binary carrying extensions where layout says in the signature of every architecture,
+//replacing anything the binary carried before. A carrier certificate is signed with key (the shared key when nil).
+//The binary keeps its size: the signature grows into the room codesign reserved for it, and stamping fails
+//with error 1144 when that is not enough. Binaries meant to be stamped must be signed with room reserved for
+//the carrier, see the package documentation.
+func (machoBinary *Binary) Stamp(extensions []pkix.Extension, key crypto.Signer, layout windows.Layout) ([]byte, error) {
+	if layout.Embedding == windows.EmbedTag {
+		return nil, errors.NewError(1145)
+	}
+	return machoBinary.rewrite(func(sig *signature) (structs.X509Certificate, error) {
+		return windows.WithCarrierExtensions(sig.stub, extensions, key, layout)
+	})
+}
+
+//Strip removes the MetaPod carriers and returns the binary as it was before it was stamped, byte for byte,
+//provided codesign left the room after the signature zeroed as it does. The one exception is a SignedData that
+//codesign wrote in BER: stamping stores it in DER, and that is how Strip gives it back.
+//A binary that carries no payload is returned unchanged.
+func (machoBinary *Binary) Strip() ([]byte, error) {
+	return machoBinary.rewrite(func(sig *signature) (structs.X509Certificate, error) {
+		return sig.stub, nil
+	})
+}
+
+//Writes the binary with the SignedData of every architecture replaced by what signedData returns.
+func (machoBinary *Binary) rewrite(signedData func(*signature) (structs.X509Certificate, error)) ([]byte, error) {
+	contents := append([]byte{}, machoBinary.contents...)
+	for _, sig := range machoBinary.signatures {
+		replacement, err := signedData(sig)
+		if err != nil {
+			return nil, err
+		}
+		superBlob, err := sig.withSignedData(replacement)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(superBlob, sig.superBlob) {
+			continue
+		}
+		region := contents[sig.offset : sig.offset+sig.size]
+		copy(region, superBlob)
+		for index := len(superBlob); index < len(region); index++ {
+			region[index] = 0
+		}
+	}
+	return contents, nil
+}
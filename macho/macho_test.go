@@ -0,0 +1,247 @@
+package macho
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+func readFixture(t testing.TB, name string) []byte {
+	contents, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func errorCode(err error) int {
+	if metapodError, ok := err.(errors.MetapodError); ok {
+		return metapodError.ErrCode()
+	}
+	return 0
+}
+
+//Rewrites the header of a fat binary with the 64 bit layout. The fixture leaves a page for the header.
+func fat64(contents []byte) []byte {
+	count := int(binary.BigEndian.Uint32(contents[4:]))
+	converted := append([]byte{}, contents...)
+	header := make([]byte, 8, 8+count*32)
+	binary.BigEndian.PutUint32(header, fatMagic64)
+	binary.BigEndian.PutUint32(header[4:], uint32(count))
+	for index := 0; index < count; index++ {
+		entry := contents[8+index*20:]
+		var entry64 [32]byte
+		copy(entry64[:8], entry[:8])
+		binary.BigEndian.PutUint64(entry64[8:], uint64(binary.BigEndian.Uint32(entry[8:])))
+		binary.BigEndian.PutUint64(entry64[16:], uint64(binary.BigEndian.Uint32(entry[12:])))
+		copy(entry64[24:28], entry[16:20])
+		header = append(header, entry64[:]...)
+	}
+	copy(converted, header)
+	return converted
+}
+
+//Leaves no room after the SuperBlob of a thin binary, as if codesign had reserved none.
+func tight(t testing.TB, contents []byte) []byte {
+	tight := append([]byte{}, contents...)
+	offset, _, err := codeSignature(tight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	length := binary.BigEndian.Uint32(tight[offset+4:])
+	index := bytes.Index(tight, []byte{lcCodeSignature, 0, 0, 0, 16, 0, 0, 0})
+	binary.LittleEndian.PutUint32(tight[index+12:], length)
+	return tight
+}
+
+//Lists the blobs of a SuperBlob by slot.
+func blobs(superBlob []byte) map[uint32][]byte {
+	slots := make(map[uint32][]byte)
+	count := int(binary.BigEndian.Uint32(superBlob[8:]))
+	for index := 0; index < count; index++ {
+		entry := superBlob[12+index*8:]
+		offset := binary.BigEndian.Uint32(entry[4:])
+		length := binary.BigEndian.Uint32(superBlob[offset+4:])
+		slots[binary.BigEndian.Uint32(entry)] = superBlob[offset : offset+length]
+	}
+	return slots
+}
+
+func TestIsBinary(t *testing.T) {
+	fat := readFixture(t, "fat.macho")
+	tests := []struct {
+		name     string
+		contents []byte
+		want     bool
+	}{
+		{"thin", readFixture(t, "thin.macho"), true},
+		{"fat", fat, true},
+		{"fat64", fat64(fat), true},
+		{"thin big endian", []byte{0xfe, 0xed, 0xfa, 0xce, 0, 0, 0, 0}, true},
+		{"Java class file", []byte{0xca, 0xfe, 0xba, 0xbe, 0, 0, 0, 0x34}, false},
+		{"no architectures", []byte{0xca, 0xfe, 0xba, 0xbe, 0, 0, 0, 0}, false},
+		{"truncated fat header", []byte{0xca, 0xfe, 0xba, 0xbe, 0, 0}, false},
+		{"portable executable", []byte("MZ\x90\x00"), false},
+		{"empty", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsBinary(test.contents); got != test.want {
+				t.Errorf("IsBinary = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStamp(t *testing.T) {
+	fat := readFixture(t, "fat.macho")
+	tests := []struct {
+		name     string
+		contents []byte
+		layout   windows.Layout
+	}{
+		{"thin certificate", readFixture(t, "thin.macho"), windows.Layout{}},
+		{"thin attribute", readFixture(t, "thin.macho"), windows.Layout{Embedding: windows.EmbedAttribute}},
+		{"BER", readFixture(t, "ber.macho"), windows.Layout{}},
+		{"fat", fat, windows.Layout{}},
+		{"fat64", fat64(fat), windows.Layout{Embedding: windows.EmbedAttribute}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machoBinary, err := ReadBinary(test.contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			extensions, err := windows.EnvelopeExtensions([]byte("payload"), "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stamped, err := machoBinary.Stamp(extensions, nil, test.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stamped) != len(test.contents) {
+				t.Fatalf("%d bytes, want %d", len(stamped), len(test.contents))
+			}
+
+			//Only the signatures may change.
+			outside, originalOutside := append([]byte{}, stamped...), append([]byte{}, test.contents...)
+			for _, sig := range machoBinary.signatures {
+				copy(outside[sig.offset:sig.offset+sig.size], make([]byte, sig.size))
+				copy(originalOutside[sig.offset:sig.offset+sig.size], make([]byte, sig.size))
+			}
+			if !bytes.Equal(outside, originalOutside) {
+				t.Error("bytes outside of the signatures changed")
+			}
+
+			stampedBinary, err := ReadBinary(stamped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for index, sig := range stampedBinary.signatures {
+				original := machoBinary.signatures[index]
+				//Every blob but the CMS one is moved along unchanged, so the SuperBlob index must follow.
+				originalBlobs, stampedBlobs := blobs(original.superBlob), blobs(sig.superBlob)
+				if len(stampedBlobs) != len(originalBlobs) {
+					t.Fatalf("architecture %d: %d blobs, want %d", index, len(stampedBlobs), len(originalBlobs))
+				}
+				for slot, blob := range originalBlobs {
+					if slot != cmsSlot && !bytes.Equal(stampedBlobs[slot], blob) {
+						t.Errorf("architecture %d: blob in slot %#x changed", index, slot)
+					}
+				}
+				if len(sig.superBlob) <= len(original.superBlob) {
+					t.Errorf("architecture %d: the SuperBlob did not grow", index)
+				}
+				if !bytes.Equal(sig.trailer, original.trailer) {
+					t.Errorf("architecture %d: the end of the CMS blob changed", index)
+				}
+			}
+			envelope, err := stampedBinary.Target().GetEnvelope()
+			if err != nil || envelope == nil || string(envelope.Payload) != "payload" {
+				t.Fatalf("GetEnvelope = %+v, %v", envelope, err)
+			}
+			if layout, stamped := stampedBinary.Target().CarrierLayout(); !stamped || layout != test.layout {
+				t.Errorf("CarrierLayout = %+v, %v, want %+v", layout, stamped, test.layout)
+			}
+
+			stripped, err := stampedBinary.Strip()
+			if err != nil {
+				t.Fatal(err)
+			}
+			strippedBinary, err := ReadBinary(stripped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//A BER signature comes back in DER.
+			for index, sig := range strippedBinary.signatures {
+				if !bytes.Equal(sig.der, machoBinary.signatures[index].der) {
+					t.Errorf("architecture %d: Strip did not restore the SignedData", index)
+				}
+			}
+			if test.name != "BER" && !bytes.Equal(stripped, test.contents) {
+				t.Error("Strip did not restore the binary")
+			}
+		})
+	}
+}
+
+func TestStripUnstamped(t *testing.T) {
+	for _, name := range []string{"thin.macho", "ber.macho", "fat.macho"} {
+		t.Run(name, func(t *testing.T) {
+			contents := readFixture(t, name)
+			machoBinary, err := ReadBinary(contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stripped, err := machoBinary.Strip()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stripped, contents) {
+				t.Error("Strip changed a binary without a payload")
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	thin := readFixture(t, "thin.macho")
+	extensions, err := windows.EnvelopeExtensions([]byte("payload"), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := func(contents []byte, layout windows.Layout) error {
+		machoBinary, err := ReadBinary(contents)
+		if err != nil {
+			return err
+		}
+		_, err = machoBinary.Stamp(extensions, nil, layout)
+		return err
+	}
+	truncatedFat := append([]byte{}, readFixture(t, "fat.macho")[:4096]...)
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"Java class file", stamp([]byte{0xca, 0xfe, 0xba, 0xbe, 0, 0, 0, 0x34, 0, 0, 0, 0}, windows.Layout{}), 1140},
+		{"truncated fat binary", stamp(truncatedFat, windows.Layout{}), 1140},
+		{"ad-hoc signature", stamp(readFixture(t, "adhoc.macho"), windows.Layout{}), 1142},
+		{"no room for the carrier", stamp(tight(t, thin), windows.Layout{}), 1144},
+		{"tag embedding", stamp(thin, windows.Layout{Embedding: windows.EmbedTag}), 1145},
+		{"no nested signature", stamp(thin, windows.Layout{Placement: windows.PlaceNested}), 1112},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if errorCode(test.err) != test.code {
+				t.Errorf("%v, want error %d", test.err, test.code)
+			}
+		})
+	}
+}
@@ -1,5 +1,9 @@
+//Memory ownership: every buffer these functions return through an output pointer is allocated with the C
+//allocator and is owned by the caller, who must release it with MetaPodFree once done with it. Nothing is
+//returned when the error code is not zero. Input buffers are copied and remain owned by the caller.
 package main
 
+// #include <stdlib.h>
 import "C"
 
 import (
@@ -12,6 +16,7 @@ import (
 //export Create
 //Creates a new executable containing the payload, based upon a given stub template.
 //Once complete, it returns a byte array containing the new Portable Executable.
+//The caller owns *output and must release it with MetaPodFree.
 //If the error code is greater than zero, an issue was encountered.
 //Use GetErrorCodeMessage to retrieve the error message.
 func Create(buffer unsafe.Pointer, count C.int, payload *C.char, output *unsafe.Pointer, outputCount *C.int) C.int {
@@ -21,7 +26,7 @@ func Create(buffer unsafe.Pointer, count C.int, payload *C.char, output *unsafe.
 	result, err := metapod.Create(stub, payloadContents)
 
 	if err != nil {
-		return C.int(err.(errors.MetapodError).ErrCode())
+		return errorCode(err)
 	}
 	*output = C.CBytes(result)
 	*outputCount = C.int(len(result))
@@ -32,6 +37,7 @@ func Create(buffer unsafe.Pointer, count C.int, payload *C.char, output *unsafe.
 //export Open
 //Opens a portable executable file from a byte stream, seeking to find a payload certificate.
 //If found, the payload will be returned as a string.
+//The caller owns *payload and must release it with MetaPodFree.
 //If the error code is greater than zero, an issue was encountered.
 //Use GetErrorCodeMessage to retrieve the error message.
 func Open(pe unsafe.Pointer, count C.int, payload **C.char, payloadCount *C.int) C.int {
@@ -40,7 +46,7 @@ func Open(pe unsafe.Pointer, count C.int, payload **C.char, payloadCount *C.int)
 	rawPayload, err := metapod.Open(buffer)
 
 	if err != nil {
-		return C.int(err.(errors.MetapodError).ErrCode())
+		return errorCode(err)
 	} else if rawPayload == nil {
 		return C.int(1050)
 	}
@@ -50,8 +56,62 @@ func Open(pe unsafe.Pointer, count C.int, payload **C.char, payloadCount *C.int)
 	return C.int(0)
 }
 
+//export CreateEx
+//Creates a new executable containing the payload, based upon a given stub template.
+//Unlike Create, the payload is given as a buffer and its length, so it may hold any bytes including NUL.
+//Once complete, it returns a byte array containing the new Portable Executable.
+//The caller owns *output and must release it with MetaPodFree.
+//If the error code is greater than zero, an issue was encountered.
+//Use GetErrorCodeMessage to retrieve the error message.
+func CreateEx(buffer unsafe.Pointer, count C.int, payload unsafe.Pointer, payloadCount C.int, output *unsafe.Pointer, outputCount *C.int) C.int {
+	stub := C.GoBytes(buffer, count)
+	payloadContents := C.GoBytes(payload, payloadCount)
+
+	result, err := metapod.Create(stub, payloadContents)
+
+	if err != nil {
+		return errorCode(err)
+	}
+	*output = C.CBytes(result)
+	*outputCount = C.int(len(result))
+
+	return 0
+}
+
+//export OpenEx
+//Opens a portable executable file from a byte stream, seeking to find a payload certificate.
+//Unlike Open, the payload is returned as a raw buffer and its length rather than a string, so it may hold any bytes.
+//The caller owns *payload and must release it with MetaPodFree.
+//If the error code is greater than zero, an issue was encountered.
+//Use GetErrorCodeMessage to retrieve the error message.
+func OpenEx(pe unsafe.Pointer, count C.int, payload *unsafe.Pointer, payloadCount *C.int) C.int {
+	buffer := C.GoBytes(pe, count)
+
+	rawPayload, err := metapod.Open(buffer)
+
+	if err != nil {
+		return errorCode(err)
+	} else if rawPayload == nil {
+		return C.int(1050)
+	}
+
+	*payload = C.CBytes(rawPayload)
+	*payloadCount = C.int(len(rawPayload))
+	return C.int(0)
+}
+
+//Returns the code of err. A panic must not cross into C, so an error that is not a MetapodError is reported
+//with the generic code 1000 instead of being asserted.
+func errorCode(err error) C.int {
+	if metapodError, ok := err.(errors.MetapodError); ok {
+		return C.int(metapodError.ErrCode())
+	}
+	return C.int(1000)
+}
+
 //export GetErrorCodeMessage
 //Returns the human readable error message for a given error code.
+//The caller owns *text and must release it with MetaPodFree.
 func GetErrorCodeMessage(code C.int, text **C.char) C.int {
 	err := errors.NewError(int(code))
 
@@ -61,5 +121,12 @@ func GetErrorCodeMessage(code C.int, text **C.char) C.int {
 	return C.int(len(errorText))
 }
 
+//export MetaPodFree
+//Releases a buffer returned by any function of this library. Passing NULL does nothing.
+//Buffers must not be released any other way: in particular Marshal.FreeHGlobal is not the C allocator's free outside Windows.
+func MetaPodFree(pointer unsafe.Pointer) {
+	C.free(pointer)
+}
+
 func main() {
 }
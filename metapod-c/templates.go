@@ -0,0 +1,89 @@
+package main
+
+// #include <stdint.h>
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/RainwayApp/metapod"
+)
+
+//Go pointers cannot be handed to C, so loaded templates are kept here and C only ever sees their handle.
+//Handles start at 1 and are never reused, so 0 is never valid and a stale handle cannot reach another template.
+var templates = struct {
+	sync.RWMutex
+	next   uintptr
+	loaded map[uintptr]*metapod.Template
+}{loaded: make(map[uintptr]*metapod.Template)}
+
+func loadedTemplate(handle C.uintptr_t) (*metapod.Template, bool) {
+	templates.RLock()
+	defer templates.RUnlock()
+	template, found := templates.loaded[uintptr(handle)]
+	return template, found
+}
+
+//export TemplateLoad
+//Parses a signed stub once, so that it can be stamped any number of times with TemplateStamp.
+//The stub is copied, the caller may release buffer as soon as this returns.
+//Once complete, *handle identifies the template until it is released with TemplateFree.
+//If the error code is greater than zero, an issue was encountered.
+//Use GetErrorCodeMessage to retrieve the error message.
+func TemplateLoad(buffer unsafe.Pointer, count C.int, handle *C.uintptr_t) C.int {
+	stub := C.GoBytes(buffer, count)
+
+	template, err := metapod.LoadTemplate(stub)
+
+	if err != nil {
+		return errorCode(err)
+	}
+
+	templates.Lock()
+	templates.next++
+	templates.loaded[templates.next] = template
+	*handle = C.uintptr_t(templates.next)
+	templates.Unlock()
+
+	return 0
+}
+
+//export TemplateStamp
+//Creates a new executable containing the payload, based upon a template loaded with TemplateLoad.
+//It may be called from any number of threads at once, with the same handle or different ones.
+//Once complete, it returns a byte array containing the new executable.
+//The caller owns *output and must release it with MetaPodFree.
+//If the error code is greater than zero, an issue was encountered.
+//Use GetErrorCodeMessage to retrieve the error message.
+func TemplateStamp(handle C.uintptr_t, payload unsafe.Pointer, payloadCount C.int, output *unsafe.Pointer, outputCount *C.int) C.int {
+	template, found := loadedTemplate(handle)
+	if !found {
+		return C.int(1110)
+	}
+	payloadContents := C.GoBytes(payload, payloadCount)
+
+	result, err := template.Stamp(payloadContents)
+
+	if err != nil {
+		return errorCode(err)
+	}
+	*output = C.CBytes(result)
+	*outputCount = C.int(len(result))
+
+	return 0
+}
+
+//export TemplateFree
+//Releases a template loaded with TemplateLoad.
+//Calls to TemplateStamp already running with the handle complete normally, later ones fail.
+//If the error code is greater than zero, the handle was not loaded or was already released.
+func TemplateFree(handle C.uintptr_t) C.int {
+	templates.Lock()
+	defer templates.Unlock()
+	if _, found := templates.loaded[uintptr(handle)]; !found {
+		return C.int(1110)
+	}
+	delete(templates.loaded, uintptr(handle))
+	return 0
+}
@@ -1,41 +1,296 @@
 package metapod
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+
+	"github.com/RainwayApp/metapod/authenticode"
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/macho"
+	"github.com/RainwayApp/metapod/msi"
+	"github.com/RainwayApp/metapod/structs"
 	"github.com/RainwayApp/metapod/windows"
 )
 
-// Create adds the payload to the target executeable and returns the result
-func Create(peFile []byte, payload []byte) ([]byte, error) {
+// Create adds the payload to the target executeable and returns the result.
+// The CheckSum of the result is recomputed unless WithoutCheckSum is passed.
+func Create(peFile []byte, payload []byte, options ...Option) ([]byte, error) {
+	template, err := LoadTemplate(peFile)
+	if err != nil {
+		return []byte{}, err
+	}
+	return template.Stamp(payload, options...)
+}
+
+// CreateTo writes the stub with the payload added to w. Only the headers and the certificate table of the
+// stub are held in memory; the rest is streamed from stub, so memory use does not depend on its size.
+func CreateTo(w io.Writer, stub io.ReaderAt, size int64, payload []byte, options ...Option) error {
+	template, err := LoadTemplateReaderAt(stub, size)
+	if err != nil {
+		return err
+	}
+	_, err = template.StampTo(w, payload, options...)
+	return err
+}
+
+// CreateEntries adds a set of named payload entries to the target executable and returns the result.
+// Anything the executable already carried is replaced.
+func CreateEntries(peFile []byte, entries map[string][]byte, options ...Option) ([]byte, error) {
+	template, err := LoadTemplate(peFile)
+	if err != nil {
+		return []byte{}, err
+	}
+	return template.StampEntries(entries, options...)
+}
+
+// SetEntry adds or replaces a single named entry without disturbing the others.
+// The file is stamped again with the embedding and placement it was stamped with, unless options choose others.
+// A payload signed with WithPayloadSigningKey must be signed again, so the key has to be passed; without it
+// SetEntry fails with error 1094 rather than drop the signature.
+func SetEntry(peFile []byte, key string, value []byte, options ...Option) ([]byte, error) {
+	return restamp(peFile, options, func(targetExecutable *windows.TargetExecutable) ([]pkix.Extension, error) {
+		return targetExecutable.SetEntryExtensions(key, value)
+	})
+}
+
+// DeleteEntry removes a single named entry without disturbing the others.
+// Like SetEntry it keeps the embedding and placement of the file and needs the payload signing key of a signed payload.
+func DeleteEntry(peFile []byte, key string, options ...Option) ([]byte, error) {
+	return restamp(peFile, options, func(targetExecutable *windows.TargetExecutable) ([]pkix.Extension, error) {
+		return targetExecutable.DeleteEntryExtensions(key)
+	})
+}
+
+// OpenReaderAt gets the payload from a file of the given size, reading only its headers and certificate table.
+// The payload may be nil with no error - this means that the file carries no payload
+func OpenReaderAt(r io.ReaderAt, size int64) ([]byte, error) {
+	if contents, isContainer, err := readContainer(r, size); isContainer {
+		if err != nil {
+			return []byte{}, err
+		}
+		return Open(contents)
+	}
+
+	portableExecutable, err := windows.ReadPortableExecutable(r, size)
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	var targetExecutable = windows.TargetExecutable{PortableExecutable: *portableExecutable}
+	_, rawPayload, err := targetExecutable.GetPayload()
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return rawPayload, nil
+}
+
+// OpenEnvelope gets the payload from a file along with its content type and how it was stored.
+// Payloads stored before envelopes existed are reported with Version 0.
+// The envelope may be nil with no error - this means that the file carries no payload
+func OpenEnvelope(peFile []byte) (*windows.Envelope, error) {
+	targetExecutable, err := target(peFile)
+
+	if err != nil {
+		return nil, err
+	}
+	return targetExecutable.GetEnvelope()
+}
+
+// OpenEntries gets the named payload entries from a file.
+// entries may be nil with no error - this means that the file carries no entries
+func OpenEntries(peFile []byte) (map[string][]byte, error) {
+	targetExecutable, err := target(peFile)
+
+	if err != nil {
+		return nil, err
+	}
+	return targetExecutable.GetEntries()
+}
+
+// Strip removes the payload from a stamped file and returns the stub it was created from, byte for byte.
+// A file that carries no payload is returned unchanged. MSI packages get their original signature back,
+// but their compound file is laid out anew rather than restored byte for byte.
+func Strip(peFile []byte) ([]byte, error) {
+	if stub, isContainer, err := parseContainer(peFile); isContainer {
+		if err != nil {
+			return nil, err
+		}
+		return stub.Strip()
+	}
+
 	portableExecutable, err := windows.GetPortableExecutable(peFile)
 
+	if err != nil {
+		return nil, err
+	}
+
+	var targetExecutable = windows.TargetExecutable{PortableExecutable: *portableExecutable}
+	return targetExecutable.Strip()
+}
+
+// Stamps the carrier extensions update returns for the file, replacing what it carried before.
+// The layout of the file is kept unless options change it, and a signed payload is never left unsigned.
+func restamp(peFile []byte, options []Option, update func(*windows.TargetExecutable) ([]pkix.Extension, error)) ([]byte, error) {
+	targetExecutable, err := target(peFile)
 	if err != nil {
 		return []byte{}, err
 	}
-	var targetExecutable = windows.TargetExecutable{*portableExecutable}
-	contents, err := targetExecutable.CreateFromTemplate(payload)
+	if layout, stamped := targetExecutable.CarrierLayout(); stamped {
+		options = append([]Option{withLayout(layout)}, options...)
+	}
+	if targetExecutable.PayloadSigned() && newSettings(options).payloadKey == nil {
+		return []byte{}, errors.NewError(1094)
+	}
+	extensions, err := update(targetExecutable)
 	if err != nil {
 		return []byte{}, err
 	}
+	template, err := LoadTemplate(peFile)
+	if err != nil {
+		return []byte{}, err
+	}
+	return template.stamp(extensions, options)
+}
+
+// A stub format other than the portable executable: an MSI package or a Mach-O binary.
+// These are read and stamped whole, in memory.
+type container interface {
+	Target() *windows.TargetExecutable
+	Stamp(extensions []pkix.Extension, key crypto.Signer, layout windows.Layout) ([]byte, error)
+	Strip() ([]byte, error)
+}
+
+// Tells whether a file starts like a container. The first eight bytes are enough to tell.
+func hasContainerMagic(magic []byte) bool {
+	return msi.IsPackage(magic) || macho.IsBinary(magic)
+}
 
-	return contents, nil
+// Parses peFile when it is a container.
+func parseContainer(peFile []byte) (stub container, isContainer bool, err error) {
+	switch {
+	case msi.IsPackage(peFile):
+		msiPackage, err := msi.ReadPackage(peFile)
+		if err != nil {
+			return nil, true, err
+		}
+		return msiPackage, true, nil
+	case macho.IsBinary(peFile):
+		machoBinary, err := macho.ReadBinary(peFile)
+		if err != nil {
+			return nil, true, err
+		}
+		return machoBinary, true, nil
+	}
+	return nil, false, nil
+}
+
+// Reads the whole file when it is a container, which cannot be read piecemeal.
+func readContainer(r io.ReaderAt, size int64) (contents []byte, isContainer bool, err error) {
+	magic := make([]byte, 8)
+	if _, err := r.ReadAt(magic, 0); err != nil || !hasContainerMagic(magic) {
+		return nil, false, nil
+	}
+	contents = make([]byte, size)
+	if _, err := r.ReadAt(contents, 0); err != nil && err != io.EOF {
+		return nil, true, errors.NewError(1034)
+	}
+	return contents, true, nil
+}
+
+// Parses a file of any supported format far enough to read what it carries.
+func target(peFile []byte) (*windows.TargetExecutable, error) {
+	if stub, isContainer, err := parseContainer(peFile); isContainer {
+		if err != nil {
+			return nil, err
+		}
+		return stub.Target(), nil
+	}
+	portableExecutable, err := windows.GetPortableExecutable(peFile)
+	if err != nil {
+		return nil, err
+	}
+	return &windows.TargetExecutable{PortableExecutable: *portableExecutable}, nil
 }
 
 // Open gets the payload from a file
 // rawPayload may return nil with no error - this means that the payload did
 // not exist
 func Open(peFile []byte) ([]byte, error) {
-	portableExecutable, err := windows.GetPortableExecutable(peFile)
+	targetExecutable, err := target(peFile)
 
 	if err != nil {
 		return []byte{}, err
 	}
+	_, rawPayload, err := targetExecutable.GetPayload()
 
-	var targetExecutable = windows.TargetExecutable{*portableExecutable}
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return rawPayload, nil
+}
+
+// OpenVerified gets the payload from a file only if it was signed with the private key matching publicKey
+// (see WithPayloadSigningKey) and has not been changed since. An unsigned payload fails with error 1091 and a
+// payload whose signature does not check out fails with 1093.
+// The payload may be nil with no error - this means that the file carries no payload, so there is nothing to verify
+func OpenVerified(peFile []byte, publicKey ed25519.PublicKey) ([]byte, error) {
+	targetExecutable, err := target(peFile)
+
+	if err != nil {
+		return []byte{}, err
+	}
 	_, rawPayload, err := targetExecutable.GetPayload()
 
 	if err != nil {
 		return []byte{}, err
 	}
 
+	if rawPayload == nil {
+		return nil, nil
+	}
+
+	if err := targetExecutable.VerifyPayloadSignature(publicKey); err != nil {
+		return []byte{}, err
+	}
+
 	return rawPayload, nil
 }
+
+// AttributeCertificates lists every WIN_CERTIFICATE entry of the attribute certificate table of a file.
+// MetaPod only ever changes the PKCS#7 signed data entry; the others are carried over byte for byte.
+func AttributeCertificates(peFile []byte) ([]structs.AttributeCertificate, error) {
+	portableExecutable, err := windows.GetPortableExecutable(peFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return portableExecutable.AttributeCertificates, nil
+}
+
+// Verify checks the Authenticode signature of a portable executable against roots
+// (the system roots when nil) and reports every check that failed.
+// Timestamps are not honoured: the chain is checked at the current time, so a file signed with a certificate
+// that has since expired fails even when its signature was timestamped.
+func Verify(peFile []byte, roots *x509.CertPool) (*authenticode.Verification, error) {
+	portableExecutable, err := windows.GetPortableExecutable(peFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return authenticode.Verify(portableExecutable, roots)
+}
+
+// ValidateCheckSum reports whether the optional header CheckSum of a portable executable matches its contents.
+// The file does not need to be signed.
+func ValidateCheckSum(peFile []byte) (bool, error) {
+	return windows.ValidateCheckSum(peFile)
+}
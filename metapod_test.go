@@ -0,0 +1,308 @@
+package metapod
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io/ioutil"
+	"testing"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+func readStub(t testing.TB) []byte {
+	stub, err := ioutil.ReadFile("testdata/stub.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stub
+}
+
+func errorCode(err error) int {
+	if metapodError, ok := err.(errors.MetapodError); ok {
+		return metapodError.ErrCode()
+	}
+	return 0
+}
+
+func TestSetEntryKeepsLayout(t *testing.T) {
+	stub := readStub(t)
+	tests := []struct {
+		name   string
+		layout windows.Layout
+	}{
+		{"outer certificate", windows.Layout{Placement: OuterSignature, Embedding: CertificateEmbedding}},
+		{"nested certificate", windows.Layout{Placement: NestedSignature, Embedding: CertificateEmbedding}},
+		{"both attribute", windows.Layout{Placement: BothSignatures, Embedding: AttributeEmbedding}},
+		{"nested attribute", windows.Layout{Placement: NestedSignature, Embedding: AttributeEmbedding}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stamped, err := CreateEntries(stub, map[string][]byte{"a": []byte("1")},
+				WithPlacement(test.layout.Placement), WithEmbedding(test.layout.Embedding))
+			if err != nil {
+				t.Fatal(err)
+			}
+			edited, err := SetEntry(stamped, "b", []byte("2"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			targetExecutable, err := target(edited)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if layout, stamped := targetExecutable.CarrierLayout(); !stamped || layout != test.layout {
+				t.Errorf("CarrierLayout = %+v, %v, want %+v", layout, stamped, test.layout)
+			}
+			entries, err := OpenEntries(edited)
+			if err != nil || string(entries["a"]) != "1" || string(entries["b"]) != "2" {
+				t.Errorf("OpenEntries = %q, %v", entries, err)
+			}
+		})
+	}
+}
+
+func TestSetEntrySignedPayload(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamped, err := CreateEntries(readStub(t), map[string][]byte{"a": []byte("1")}, WithPayloadSigningKey(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SetEntry(stamped, "b", []byte("2")); errorCode(err) != 1094 {
+		t.Fatalf("SetEntry without the signing key: %v, want error 1094", err)
+	}
+	if _, err := DeleteEntry(stamped, "a"); errorCode(err) != 1094 {
+		t.Fatalf("DeleteEntry without the signing key: %v, want error 1094", err)
+	}
+
+	edited, err := SetEntry(stamped, "b", []byte("2"), WithPayloadSigningKey(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetExecutable, err := target(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := targetExecutable.VerifyPayloadSignature(publicKey); err != nil {
+		t.Errorf("VerifyPayloadSignature: %v", err)
+	}
+}
+
+func TestSetEntryTagEmbedding(t *testing.T) {
+	stamped, err := Create(readStub(t), []byte("tagged"), WithEmbedding(TagEmbedding))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Entries cannot be stored in a tag, and the tagged payload must not be dropped silently.
+	if _, err := SetEntry(stamped, "a", []byte("1")); errorCode(err) != 1116 {
+		t.Fatalf("SetEntry on a tagged file: %v, want error 1116", err)
+	}
+}
+
+func TestOpenVerified(t *testing.T) {
+	stub := readStub(t)
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("signed payload")
+	signed, err := Create(stub, payload, WithPayloadSigningKey(privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := Create(stub, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another payload stamped along with the signature made over the first one.
+	template, err := LoadTemplate(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedExtensions, err := newSettings([]Option{WithPayloadSigningKey(privateKey)}).payloadExtensions(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extensions, err := windows.EnvelopeExtensions([]byte("forged payload"), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered, err := template.stampExtensions(append(extensions, signedExtensions[len(signedExtensions)-1]), newSettings(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		file      []byte
+		publicKey ed25519.PublicKey
+		code      int
+	}{
+		{"signed", signed, publicKey, 0},
+		{"unsigned", unsigned, publicKey, 1091},
+		{"tampered payload", tampered, publicKey, 1093},
+		{"other key", signed, otherPublicKey, 1093},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opened, err := OpenVerified(test.file, test.publicKey)
+			if errorCode(err) != test.code {
+				t.Fatalf("%v, want error %d", err, test.code)
+			}
+			if test.code == 0 && !bytes.Equal(opened, payload) {
+				t.Errorf("OpenVerified = %q", opened)
+			}
+		})
+	}
+	// The forged payload is still there for Open, which does not check signatures.
+	if opened, err := Open(tampered); err != nil || string(opened) != "forged payload" {
+		t.Errorf("Open = %q, %v", opened, err)
+	}
+}
+
+func TestNestedPlacement(t *testing.T) {
+	stub := readStub(t)
+	singleSigned, err := ioutil.ReadFile("windows/testdata/pe32.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, placement := range []Placement{NestedSignature, BothSignatures} {
+		if _, err := Create(singleSigned, []byte("payload"), WithPlacement(placement)); errorCode(err) != 1112 {
+			t.Errorf("placement %v without a nested signature: %v, want error 1112", placement, err)
+		}
+	}
+
+	stamped, err := Create(stub, []byte("payload"), WithPlacement(NestedSignature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := Open(stamped); err != nil || string(opened) != "payload" {
+		t.Fatalf("Open = %q, %v", opened, err)
+	}
+	original, err := target(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetExecutable, err := target(stamped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The outer signature keeps its certificates; the carrier goes into the nested one.
+	if got, want := len(targetExecutable.X509Certificate.PKCS7.Certificates), len(original.X509Certificate.PKCS7.Certificates); got != want {
+		t.Errorf("%d outer certificates, want %d", got, want)
+	}
+	if layout, stamped := targetExecutable.CarrierLayout(); !stamped || layout.Placement != NestedSignature {
+		t.Errorf("CarrierLayout = %+v, %v", layout, stamped)
+	}
+	stripped, err := Strip(stamped)
+	if err != nil || !bytes.Equal(stripped, stub) {
+		t.Errorf("Strip did not restore the stub: %v", err)
+	}
+}
+
+func TestAttributeEmbedding(t *testing.T) {
+	stub := readStub(t)
+	original, err := target(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, signingKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := bytes.Repeat([]byte("attribute payload "), 100)
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{"plain", nil},
+		{"compressed with a content type", []Option{WithCompression(), WithContentType("application/json")}},
+		{"signed", []Option{WithPayloadSigningKey(signingKey)}},
+		{"both signatures", []Option{WithPlacement(BothSignatures)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stamped, err := Create(stub, payload, append(test.options, WithEmbedding(AttributeEmbedding))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opened, err := Open(stamped); err != nil || !bytes.Equal(opened, payload) {
+				t.Fatalf("Open = %d bytes, %v", len(opened), err)
+			}
+			targetExecutable, err := target(stamped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// No carrier certificate is added to the signature.
+			if got, want := len(targetExecutable.X509Certificate.PKCS7.Certificates), len(original.X509Certificate.PKCS7.Certificates); got != want {
+				t.Errorf("%d certificates, want %d", got, want)
+			}
+			if layout, stamped := targetExecutable.CarrierLayout(); !stamped || layout.Embedding != AttributeEmbedding {
+				t.Errorf("CarrierLayout = %+v, %v", layout, stamped)
+			}
+
+			// Stamping again replaces the attribute rather than adding a second one.
+			restamped, err := Create(stamped, []byte("second"), WithEmbedding(AttributeEmbedding))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opened, err := Open(restamped); err != nil || string(opened) != "second" {
+				t.Errorf("Open after restamping = %q, %v", opened, err)
+			}
+			for _, file := range [][]byte{stamped, restamped} {
+				if stripped, err := Strip(file); err != nil || !bytes.Equal(stripped, stub) {
+					t.Errorf("Strip did not restore the stub: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTagEmbedding(t *testing.T) {
+	stub := readStub(t)
+	_, signingKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	largest := bytes.Repeat([]byte{'t'}, windows.MaxTagPayloadSize)
+	stamped, err := Create(stub, largest, WithEmbedding(TagEmbedding))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := Open(stamped); err != nil || !bytes.Equal(opened, largest) {
+		t.Errorf("Open = %d bytes, %v", len(opened), err)
+	}
+	if stripped, err := Strip(stamped); err != nil || !bytes.Equal(stripped, stub) {
+		t.Errorf("Strip did not restore the stub: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		payload []byte
+		options []Option
+		code    int
+	}{
+		{"too large", append(largest, 't'), nil, 1117},
+		{"compression", bytes.Repeat([]byte("payload"), 100), []Option{WithCompression()}, 1116},
+		{"compression that does not help", []byte("payload"), []Option{WithCompression()}, 1116},
+		{"content type", []byte("payload"), []Option{WithContentType("text/plain")}, 1116},
+		{"payload signature", []byte("payload"), []Option{WithPayloadSigningKey(signingKey)}, 1116},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Create(stub, test.payload, append(test.options, WithEmbedding(TagEmbedding))...)
+			if errorCode(err) != test.code {
+				t.Errorf("%v, want error %d", err, test.code)
+			}
+		})
+	}
+}
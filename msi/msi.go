@@ -0,0 +1,113 @@
+//Package msi stamps Windows Installer packages. An MSI file is a compound file whose Authenticode signature is
+//a PKCS#7 SignedData stored in the \x05DigitalSignature stream, so it can carry a payload the same way the
+//certificate table of a portable executable does.
+package msi
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/RainwayApp/metapod/compound"
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+//The stream of the root storage holding the signature. The signed digest covers every other stream and storage,
+//but neither this stream nor \x05MsiDigitalSignatureEx, which is left as it is.
+const signatureStream = "\x05DigitalSignature"
+
+//Package is a signed MSI file reduced to what stamping needs. Like windows.Template it is never modified once
+//created, so one Package can stamp from many goroutines at once.
+type Package struct {
+	contents []byte
+	file     *compound.File
+	//The directory entry of the signature stream.
+	signatureIndex int
+	//The signature as it is stored in the package.
+	SignedData *structs.X509Certificate
+	//The signature without any carrier.
+	stub structs.X509Certificate
+}
+
+//IsPackage tells whether contents look like an MSI file, that is a compound file.
+func IsPackage(contents []byte) bool {
+	return compound.IsCompoundFile(contents)
+}
+
+//ReadPackage parses a signed MSI file. The contents are referenced rather than copied and must not change
+//while the Package is in use.
+func ReadPackage(contents []byte) (*Package, error) {
+	file, err := compound.Read(contents)
+	if err != nil {
+		return nil, err
+	}
+	index, found := file.Lookup(signatureStream)
+	if !found || file.Entries[index].Type() != compound.TypeStream {
+		return nil, errors.NewError(1130)
+	}
+	signedData, err := windows.ParseSignedData(file.Entries[index].Data)
+	if err != nil {
+		return nil, err
+	}
+	stub, err := windows.WithoutCarriers(*signedData)
+	if err != nil {
+		return nil, err
+	}
+	return &Package{
+		contents:       contents,
+		file:           file,
+		signatureIndex: index,
+		SignedData:     signedData,
+		stub:           stub,
+	}, nil
+}
+
+//Target wraps the signature of the package so that its carrier can be read with the methods of windows.TargetExecutable.
+//Only the signature is filled in; methods that need the layout of a portable executable must not be called on it.
+func (msiPackage *Package) Target() *windows.TargetExecutable {
+	return &windows.TargetExecutable{
+		PortableExecutable: structs.PortableExecutable{
+			Contents:        msiPackage.contents,
+			Size:            int64(len(msiPackage.contents)),
+			Asn1Data:        msiPackage.file.Entries[msiPackage.signatureIndex].Data,
+			X509Certificate: msiPackage.SignedData,
+		},
+	}
+}
+
+//Stamp creates a new package carrying extensions where layout says, replacing anything the package carried before.
+//A carrier certificate is signed with key (the shared key when nil). Appended tags have nowhere to go in an MSI file.
+//Every stream but the signature is copied as it is, so the signature stays valid.
+func (msiPackage *Package) Stamp(extensions []pkix.Extension, key crypto.Signer, layout windows.Layout) ([]byte, error) {
+	if layout.Embedding == windows.EmbedTag {
+		return nil, errors.NewError(1131)
+	}
+	signedData, err := windows.WithCarrierExtensions(msiPackage.stub, extensions, key, layout)
+	if err != nil {
+		return nil, err
+	}
+	return msiPackage.withSignature(signedData)
+}
+
+//Strip removes the MetaPod carriers and returns the package with its original signature.
+//The streams keep their contents but the compound file is laid out anew, so unlike Strip on a portable
+//executable the result is not byte for byte the file that was stamped. A package that carries no payload
+//is returned unchanged.
+func (msiPackage *Package) Strip() ([]byte, error) {
+	return msiPackage.withSignature(msiPackage.stub)
+}
+
+//Writes the package with signedData in place of its signature.
+func (msiPackage *Package) withSignature(signedData structs.X509Certificate) ([]byte, error) {
+	der, err := asn1.Marshal(signedData)
+	if err != nil {
+		return nil, errors.NewError(1042)
+	}
+	if bytes.Equal(der, msiPackage.file.Entries[msiPackage.signatureIndex].Data) {
+		return append([]byte{}, msiPackage.contents...), nil
+	}
+	return msiPackage.file.WithData(msiPackage.signatureIndex, der).Bytes()
+}
@@ -0,0 +1,167 @@
+package msi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/RainwayApp/metapod/compound"
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+func readFixture(t testing.TB, name string) []byte {
+	contents, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func errorCode(err error) int {
+	if metapodError, ok := err.(errors.MetapodError); ok {
+		return metapodError.ErrCode()
+	}
+	return 0
+}
+
+//Checks that every stream of stamped but the signature holds what it held in original.
+func sameStreams(t *testing.T, original, stamped []byte) {
+	t.Helper()
+	originalFile, err := compound.Read(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stampedFile, err := compound.Read(stamped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stampedFile.Entries) != len(originalFile.Entries) {
+		t.Fatalf("%d entries, want %d", len(stampedFile.Entries), len(originalFile.Entries))
+	}
+	for index, entry := range stampedFile.Entries {
+		if entry.Name() == signatureStream {
+			continue
+		}
+		if want := originalFile.Entries[index]; entry.Name() != want.Name() || !bytes.Equal(entry.Data, want.Data) {
+			t.Errorf("entry %d %q changed", index, want.Name())
+		}
+	}
+}
+
+func TestStamp(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		layout  windows.Layout
+		payload []byte
+	}{
+		{"outer certificate", "signed3.msi", windows.Layout{}, []byte("payload")},
+		{"outer attribute", "signed3.msi", windows.Layout{Embedding: windows.EmbedAttribute}, []byte("payload")},
+		//The signature grows out of the mini stream.
+		{"large payload", "signed3.msi", windows.Layout{}, bytes.Repeat([]byte("payload"), 1000)},
+		{"nested certificate", "signed4.msi", windows.Layout{Placement: windows.PlaceNested}, []byte("payload")},
+		{"both attribute", "signed4.msi", windows.Layout{Placement: windows.PlaceBoth, Embedding: windows.EmbedAttribute}, []byte("payload")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := readFixture(t, test.fixture)
+			msiPackage, err := ReadPackage(original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			extensions, err := windows.EnvelopeExtensions(test.payload, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stamped, err := msiPackage.Stamp(extensions, nil, test.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sameStreams(t, original, stamped)
+
+			stampedPackage, err := ReadPackage(stamped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			envelope, err := stampedPackage.Target().GetEnvelope()
+			if err != nil || envelope == nil || !bytes.Equal(envelope.Payload, test.payload) {
+				t.Fatalf("GetEnvelope = %+v, %v", envelope, err)
+			}
+			if layout, stamped := stampedPackage.Target().CarrierLayout(); !stamped || layout != test.layout {
+				t.Errorf("CarrierLayout = %+v, %v, want %+v", layout, stamped, test.layout)
+			}
+
+			stripped, err := stampedPackage.Strip()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sameStreams(t, original, stripped)
+			strippedFile, err := compound.Read(stripped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			index, _ := strippedFile.Lookup(signatureStream)
+			originalFile, _ := compound.Read(original)
+			originalIndex, _ := originalFile.Lookup(signatureStream)
+			if !bytes.Equal(strippedFile.Entries[index].Data, originalFile.Entries[originalIndex].Data) {
+				t.Error("Strip did not restore the signature")
+			}
+		})
+	}
+}
+
+func TestStripUnstamped(t *testing.T) {
+	original := readFixture(t, "signed3.msi")
+	msiPackage, err := ReadPackage(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped, err := msiPackage.Strip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stripped, original) {
+		t.Error("Strip changed a package without a payload")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	signed3 := readFixture(t, "signed3.msi")
+	var name []byte
+	for _, unit := range utf16.Encode([]rune(signatureStream)) {
+		name = append(name, byte(unit), byte(unit>>8))
+	}
+	renamed := bytes.Replace(signed3, name, bytes.Replace(name, []byte("D\x00"), []byte("X\x00"), 1), 1)
+	extensions, err := windows.EnvelopeExtensions([]byte("payload"), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := func(layout windows.Layout) error {
+		msiPackage, err := ReadPackage(signed3)
+		if err != nil {
+			return err
+		}
+		_, err = msiPackage.Stamp(extensions, nil, layout)
+		return err
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"no signature stream", func() error { _, err := ReadPackage(renamed); return err }(), 1130},
+		{"not a compound file", func() error { _, err := ReadPackage([]byte("MZ")); return err }(), 1120},
+		{"tag embedding", stamp(windows.Layout{Embedding: windows.EmbedTag}), 1131},
+		{"no nested signature", stamp(windows.Layout{Placement: windows.PlaceNested}), 1112},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if errorCode(test.err) != test.code {
+				t.Errorf("%v, want error %d", test.err, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package metapod
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+// Option changes how Create and the other stamping functions write an executable.
+type Option func(*settings)
+
+type settings struct {
+	skipCheckSum bool
+	carrierKey   crypto.Signer
+	payloadKey   ed25519.PrivateKey
+	compress     bool
+	contentType  string
+	layout       windows.Layout
+}
+
+func newSettings(options []Option) *settings {
+	var s settings
+	for _, option := range options {
+		option(&s)
+	}
+	return &s
+}
+
+// WithoutCheckSum leaves the optional header CheckSum of the output as it was in the stub
+// instead of recomputing it for the stamped file.
+func WithoutCheckSum() Option {
+	return func(s *settings) {
+		s.skipCheckSum = true
+	}
+}
+
+// WithCarrierKey signs the carrier certificate with key instead of the RSA key shared by the process.
+// Any crypto.Signer accepted by x509.CreateCertificate works; see GenerateCarrierKey.
+func WithCarrierKey(key crypto.Signer) Option {
+	return func(s *settings) {
+		s.carrierKey = key
+	}
+}
+
+// WithPayloadSigningKey signs the payload with a vendor Ed25519 key, so that a client holding the public key
+// can tell the payload came from the vendor with OpenVerified. Unlike the carrier key, this key must be kept secret.
+func WithPayloadSigningKey(key ed25519.PrivateKey) Option {
+	return func(s *settings) {
+		s.payloadKey = key
+	}
+}
+
+// WithCompression stores the payload compressed with DEFLATE when that makes the stamped file smaller.
+// Open decompresses it transparently; payloads that would expand beyond windows.MaxDecompressedSize are stored as they are.
+// Sealed payloads and entries are never compressed, and TagEmbedding refuses compression with error 1116.
+func WithCompression() Option {
+	return func(s *settings) {
+		s.compress = true
+	}
+}
+
+// WithContentType records the media type of the payload (for example "application/json") in its envelope,
+// where OpenEnvelope reports it. It may be at most 255 bytes long.
+func WithContentType(contentType string) Option {
+	return func(s *settings) {
+		s.contentType = contentType
+	}
+}
+
+// Placement selects which signatures of a dual signed executable carry the payload.
+// Open searches the outer signature and every nested one, whatever the placement.
+type Placement = windows.Placement
+
+const (
+	// OuterSignature adds the carrier certificate to the outer signature. This is the default.
+	OuterSignature = windows.PlaceOuter
+	// NestedSignature adds the carrier certificate to the first signature nested in the outer one
+	// (the SPC_NESTED_SIGNATURE attribute of a dual signed executable), leaving the outer signature untouched.
+	NestedSignature = windows.PlaceNested
+	// BothSignatures adds the carrier certificate to the outer signature and to the first nested one.
+	BothSignatures = windows.PlaceBoth
+)
+
+// WithPlacement chooses which signatures carry the payload. Stubs without a nested signature fail with
+// error 1112 unless the placement is OuterSignature.
+func WithPlacement(placement Placement) Option {
+	return func(s *settings) {
+		s.layout.Placement = placement
+	}
+}
+
+// Embedding selects how the payload is stored within a signature. Open detects either way on its own.
+type Embedding = windows.Embedding
+
+const (
+	// CertificateEmbedding stores the payload in an expired, self-issued X.509 certificate added to the signature.
+	// This is the default and what older versions of MetaPod read.
+	CertificateEmbedding = windows.EmbedCertificate
+	// AttributeEmbedding stores the payload as an unauthenticated attribute of the SignerInfo, so no stray certificate
+	// shows up in signature viewers or security scanners. WithCarrierKey has no effect with this embedding.
+	AttributeEmbedding = windows.EmbedAttribute
+	// TagEmbedding appends the payload after the signature as an Omaha style tag: the magic "Gact", the length of
+	// the payload as a big endian uint16 and the payload itself, so the stub can find it by scanning its own file.
+	// Only plain payloads of up to windows.MaxTagPayloadSize bytes can be stored this way, without compression,
+	// content type or payload signature.
+	TagEmbedding = windows.EmbedTag
+)
+
+// WithEmbedding chooses how the payload is stored within a signature.
+func WithEmbedding(embedding Embedding) Option {
+	return func(s *settings) {
+		s.layout.Embedding = embedding
+	}
+}
+
+// Stamps where layout says, as if both WithPlacement and WithEmbedding had been given.
+func withLayout(layout windows.Layout) Option {
+	return func(s *settings) {
+		s.layout = layout
+	}
+}
+
+// The carrier extensions holding a plain payload, enveloped and signed as asked.
+func (s *settings) payloadExtensions(payload []byte) ([]pkix.Extension, error) {
+	// A payload is only stored compressed when that makes it smaller, so refuse the combination up front
+	// rather than let the outcome depend on the payload.
+	if s.compress && s.layout.Embedding == windows.EmbedTag {
+		return nil, errors.NewError(1116)
+	}
+	extensions, err := windows.EnvelopeExtensions(payload, s.contentType, s.compress)
+	if err != nil {
+		return nil, err
+	}
+	return s.sign(extensions)
+}
+
+// Adds the payload signature to extensions when a payload signing key was given.
+func (s *settings) sign(extensions []pkix.Extension) ([]pkix.Extension, error) {
+	if s.payloadKey == nil {
+		return extensions, nil
+	}
+	return windows.SignExtensions(extensions, s.payloadKey)
+}
+
+// CarrierKeyAlgorithm selects the type of key GenerateCarrierKey creates.
+type CarrierKeyAlgorithm int
+
+const (
+	// RSA2048 matches the carrier certificates MetaPod has always produced.
+	RSA2048 CarrierKeyAlgorithm = iota
+	// ECDSAP256 is much cheaper to sign with and yields a smaller carrier. Its signatures are randomized,
+	// so stamping the same payload twice does not give identical files.
+	ECDSAP256
+	// Ed25519 is the cheapest to sign with and yields the smallest carrier.
+	Ed25519
+)
+
+// GenerateCarrierKey creates a key to be reused across stamps with WithCarrierKey.
+func GenerateCarrierKey(algorithm CarrierKeyAlgorithm) (crypto.Signer, error) {
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case RSA2048:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ECDSAP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case Ed25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, errors.NewError(1044)
+	}
+	if err != nil {
+		return nil, errors.NewError(1040)
+	}
+	return key, nil
+}
@@ -0,0 +1,53 @@
+package metapod
+
+import (
+	"github.com/RainwayApp/metapod/windows"
+)
+
+// SealingKey is an AES-256 key together with the ID stored next to every payload sealed with it.
+// The ID is not secret. Keeping retired keys around under their own IDs lets files sealed before
+// a rotation still be opened.
+type SealingKey struct {
+	ID string
+	// Key must be 32 bytes long.
+	Key []byte
+}
+
+// CreateSealed adds the payload, encrypted and authenticated with AES-256-GCM under key, to the target executable
+// and returns the result. Open refuses such a file; use OpenSealed.
+func CreateSealed(peFile []byte, payload []byte, key SealingKey, options ...Option) ([]byte, error) {
+	template, err := LoadTemplate(peFile)
+	if err != nil {
+		return []byte{}, err
+	}
+	return template.StampSealed(payload, key, options...)
+}
+
+// StampSealed returns a new executable carrying payload sealed under key.
+func (template *Template) StampSealed(payload []byte, key SealingKey, options ...Option) ([]byte, error) {
+	extensions, err := windows.SealedExtensions(payload, key.ID, key.Key)
+	if err != nil {
+		return nil, err
+	}
+	return template.stamp(extensions, options)
+}
+
+// OpenSealed gets the sealed payload from a file, decrypting it with the key whose ID it was sealed under.
+// A payload that was tampered with or sealed under a different key with the same ID fails with error 1082.
+// An unknown key ID fails with 1081, and a file carrying an unsealed payload fails with 1085.
+// The payload may be nil with no error - this means that the file carries no payload, sealed or not
+func OpenSealed(peFile []byte, keys ...SealingKey) ([]byte, error) {
+	targetExecutable, err := target(peFile)
+
+	if err != nil {
+		return []byte{}, err
+	}
+	return targetExecutable.GetSealedPayload(func(keyID string) ([]byte, bool) {
+		for _, key := range keys {
+			if key.ID == keyID {
+				return key.Key, true
+			}
+		}
+		return nil, false
+	})
+}
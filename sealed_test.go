@@ -0,0 +1,67 @@
+package metapod
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestOpenSealed(t *testing.T) {
+	stub := readStub(t)
+	key := SealingKey{ID: "2026-10", Key: bytes.Repeat([]byte{1}, 32)}
+	payload := []byte("sealed payload")
+	sealed, err := CreateSealed(stub, payload, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := Create(stub, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flips a byte of the ciphertext, which GCM authenticates.
+	targetExecutable, err := target(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored struct {
+		KeyID      string `asn1:"utf8"`
+		Nonce      []byte
+		Ciphertext []byte
+	}
+	for _, extension := range targetExecutable.CarrierExtensions() {
+		if _, err := asn1.Unmarshal(extension.Value, &stored); err == nil && stored.KeyID == key.ID {
+			break
+		}
+	}
+	index := bytes.Index(sealed, stored.Ciphertext)
+	if len(stored.Ciphertext) == 0 || index < 0 {
+		t.Fatal("ciphertext not found")
+	}
+	tampered := append([]byte{}, sealed...)
+	tampered[index] ^= 1
+
+	tests := []struct {
+		name string
+		file []byte
+		keys []SealingKey
+		code int
+	}{
+		{"right key", sealed, []SealingKey{{ID: "retired", Key: make([]byte, 32)}, key}, 0},
+		{"wrong key ID", sealed, []SealingKey{{ID: "2026-04", Key: key.Key}}, 1081},
+		{"wrong key", sealed, []SealingKey{{ID: key.ID, Key: bytes.Repeat([]byte{2}, 32)}}, 1082},
+		{"tampered ciphertext", tampered, []SealingKey{key}, 1082},
+		{"plain payload", plain, []SealingKey{key}, 1085},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opened, err := OpenSealed(test.file, test.keys...)
+			if errorCode(err) != test.code {
+				t.Fatalf("%v, want error %d", err, test.code)
+			}
+			if test.code == 0 && !bytes.Equal(opened, payload) {
+				t.Errorf("OpenSealed = %q", opened)
+			}
+		})
+	}
+}
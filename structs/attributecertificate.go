@@ -0,0 +1,16 @@
+package structs
+
+//Represents a WIN_CERTIFICATE entry of the attribute certificate table.
+//http://msdn.microsoft.com/en-us/library/ms920091.aspx
+type AttributeCertificate struct {
+	//The offset of the entry from the start of the file.
+	Offset int
+	//dwLength: the length of the entry, header included but padding excluded.
+	Length uint32
+	//wRevision: 0x100 or 0x200.
+	Revision uint16
+	//wCertificateType: 1 for X.509, 2 for PKCS#7 signed data, 4 for a terminal server protocol stack certificate.
+	Type uint16
+	//The entry exactly as stored, from its header up to the start of the next entry, padding included.
+	Raw []byte
+}
@@ -94,6 +94,7 @@ type OptionalHeader32 struct {
 
 /// <summary>
 /// Represents the IMAGE_OPTIONAL_HEADER64 structure format <see href="https://docs.microsoft.com/en-us/windows/desktop/api/winnt/ns-winnt-image_optional_header64">HERE</see>
+/// Unlike the 32-bit layout there is no BaseOfData member; ImageBase is widened to 64 bits in its place.
 /// </summary>
 type OptionalHeader64 struct {
 	Magic                       uint16
@@ -104,7 +105,6 @@ type OptionalHeader64 struct {
 	SizeOfUninitializedData     uint32
 	AddressOfEntryPoint         uint32
 	BaseOfCode                  uint32
-	BaseOfData                  uint32
 	ImageBase                   uint64
 	SectionAlignment            uint32
 	FileAlignment               uint32
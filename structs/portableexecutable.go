@@ -2,12 +2,22 @@ package structs
 
 // PortableExecutable represents a PE binary.
 type PortableExecutable struct {
-	//The contents of the loaded file.
+	//The contents of the loaded file. nil when only the headers and certificate table were read.
 	Contents []byte
+	//The headers of the file, up to the end of the section table.
+	Headers []byte
+	//The length of the whole file.
+	Size int64
 	//The offset to the certificates table.
 	AttrCertOffset int
 	//The offset to the size of the certificates.
 	CertSizeOffset int
+	//The offset to the optional header CheckSum.
+	CheckSumOffset int
+	//Every WIN_CERTIFICATE of the attribute certificate table, in the order they are stored.
+	AttributeCertificates []AttributeCertificate
+	//The index within AttributeCertificates of the PKCS#7 signed data entry, the one the fields below are read from.
+	SignedDataIndex int
 	//The embedded X509Certificate (DER).
 	Asn1Data []byte
 	//Any extra data, if any.
@@ -0,0 +1,31 @@
+package structs
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+)
+
+// Represents a PKCS#7 SignerInfo, one per signature in the SignerInfos set.
+// The attribute sets are kept raw so that they can be hashed exactly as they were encoded.
+type SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     IssuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// Identifies the certificate of a signer by its issuer and serial number.
+type IssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// Represents a single authenticated or unauthenticated attribute of a SignerInfo.
+type Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
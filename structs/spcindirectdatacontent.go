@@ -0,0 +1,32 @@
+package structs
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+// Represents the PKCS#7 ContentInfo that is signed by an Authenticode signature.
+// For a portable executable the content is always a SpcIndirectDataContent.
+type ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// Represents the SpcIndirectDataContent structure from the Authenticode specification.
+// It binds the signature to the image through the digest of the file.
+type SpcIndirectDataContent struct {
+	Data          SpcAttributeTypeAndOptionalValue
+	MessageDigest DigestInfo
+}
+
+// Describes what was hashed, for executables this is a SpcPeImageData.
+type SpcAttributeTypeAndOptionalValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"optional"`
+}
+
+// Represents the DigestInfo structure, the algorithm and the resulting image hash.
+type DigestInfo struct {
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	Digest          []byte
+}
@@ -0,0 +1,140 @@
+package metapod
+
+import (
+	"crypto/x509/pkix"
+	"io"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/windows"
+)
+
+// Template is a stub that has been parsed once and can then be stamped any number of times.
+// Everything that does not depend on the payload is computed up front, and a Template is never
+// modified afterwards, so it is safe to call Stamp from many goroutines at once.
+type Template struct {
+	template *windows.Template
+	// Set instead of template when the stub is an MSI package or a Mach-O binary.
+	container container
+}
+
+// LoadTemplate parses a signed stub, a portable executable, an MSI package or a Mach-O binary, for repeated stamping.
+// The stub is referenced rather than copied and must not be modified while the Template is in use.
+func LoadTemplate(peFile []byte) (*Template, error) {
+	if stub, isContainer, err := parseContainer(peFile); isContainer {
+		if err != nil {
+			return nil, err
+		}
+		return &Template{container: stub}, nil
+	}
+
+	portableExecutable, err := windows.GetPortableExecutable(peFile)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := windows.NewTemplate(portableExecutable)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{template: template}, nil
+}
+
+// LoadTemplateReaderAt parses a signed stub of the given size for repeated stamping, reading only its headers
+// and certificate table. The rest of the stub is streamed from r by every call to StampTo.
+// MSI packages and Mach-O binaries cannot be streamed and are read into memory whole.
+func LoadTemplateReaderAt(r io.ReaderAt, size int64) (*Template, error) {
+	if contents, isContainer, err := readContainer(r, size); isContainer {
+		if err != nil {
+			return nil, err
+		}
+		return LoadTemplate(contents)
+	}
+
+	portableExecutable, err := windows.ReadPortableExecutable(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := windows.NewTemplateReaderAt(portableExecutable, r)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{template: template}, nil
+}
+
+// Stamp returns a new executable carrying payload.
+func (template *Template) Stamp(payload []byte, options ...Option) ([]byte, error) {
+	settings := newSettings(options)
+	extensions, err := settings.payloadExtensions(payload)
+	if err != nil {
+		return nil, err
+	}
+	return template.stampExtensions(extensions, settings)
+}
+
+// StampEntries returns a new executable carrying a set of named payload entries.
+func (template *Template) StampEntries(entries map[string][]byte, options ...Option) ([]byte, error) {
+	extensions, err := windows.EntriesExtensions(entries)
+	if err != nil {
+		return nil, err
+	}
+	return template.stamp(extensions, options)
+}
+
+// Stamps the carrier extensions, signing them first when asked to.
+func (template *Template) stamp(extensions []pkix.Extension, options []Option) ([]byte, error) {
+	settings := newSettings(options)
+	extensions, err := settings.sign(extensions)
+	if err != nil {
+		return nil, err
+	}
+	return template.stampExtensions(extensions, settings)
+}
+
+// Stamps the carrier extensions in the format of the stub.
+func (template *Template) stampExtensions(extensions []pkix.Extension, settings *settings) ([]byte, error) {
+	if template.container != nil {
+		return template.container.Stamp(extensions, settings.carrierKey, settings.layout)
+	}
+	return template.template.Stamp(extensions, settings.carrierKey, settings.layout, !settings.skipCheckSum)
+}
+
+// StampTo writes a new executable carrying payload to w and returns the number of bytes written.
+// Memory use does not depend on the size of the stub, unless it is an MSI package or a Mach-O binary.
+func (template *Template) StampTo(w io.Writer, payload []byte, options ...Option) (int64, error) {
+	settings := newSettings(options)
+	extensions, err := settings.payloadExtensions(payload)
+	if err != nil {
+		return 0, err
+	}
+	if template.container != nil {
+		contents, err := template.stampExtensions(extensions, settings)
+		if err != nil {
+			return 0, err
+		}
+		written, err := w.Write(contents)
+		if err != nil {
+			return int64(written), errors.NewError(1035)
+		}
+		return int64(written), nil
+	}
+	return template.template.StampTo(w, extensions, settings.carrierKey, settings.layout, !settings.skipCheckSum)
+}
+
+// StampReader returns a new executable carrying payload as a reader that can seek and read at any offset.
+// Only the headers and the certificate table are held in memory; the rest is read from the stub on demand.
+func (template *Template) StampReader(payload []byte, options ...Option) (*windows.Stamped, error) {
+	settings := newSettings(options)
+	extensions, err := settings.payloadExtensions(payload)
+	if err != nil {
+		return nil, err
+	}
+	if template.container != nil {
+		contents, err := template.stampExtensions(extensions, settings)
+		if err != nil {
+			return nil, err
+		}
+		return windows.StampedBytes(contents), nil
+	}
+	return template.template.StampReader(extensions, settings.carrierKey, settings.layout, !settings.skipCheckSum)
+}
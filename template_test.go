@@ -0,0 +1,52 @@
+package metapod
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/RainwayApp/metapod/windows"
+)
+
+// A Template is shared by every goroutine, so run this with -race.
+func TestTemplateConcurrent(t *testing.T) {
+	stub := readStub(t)
+	template, err := LoadTemplate(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layouts := []windows.Layout{
+		{Placement: OuterSignature, Embedding: CertificateEmbedding},
+		{Placement: NestedSignature, Embedding: CertificateEmbedding},
+		{Placement: BothSignatures, Embedding: AttributeEmbedding},
+		{Placement: OuterSignature, Embedding: TagEmbedding},
+	}
+
+	var group sync.WaitGroup
+	for index := 0; index < 16; index++ {
+		group.Add(1)
+		go func(index int) {
+			defer group.Done()
+			layout := layouts[index%len(layouts)]
+			payload := []byte(fmt.Sprintf("payload %d", index))
+			stamped, err := template.Stamp(payload, withLayout(layout))
+			if err != nil {
+				t.Errorf("%d: Stamp: %v", index, err)
+				return
+			}
+			if opened, err := Open(stamped); err != nil || !bytes.Equal(opened, payload) {
+				t.Errorf("%d: Open = %q, %v", index, opened, err)
+			}
+			stripped, err := Strip(stamped)
+			if err != nil {
+				t.Errorf("%d: Strip: %v", index, err)
+				return
+			}
+			if !bytes.Equal(stripped, stub) {
+				t.Errorf("%d: Strip did not restore the stub", index)
+			}
+		}(index)
+	}
+	group.Wait()
+}
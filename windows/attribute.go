@@ -0,0 +1,154 @@
+package windows
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+)
+
+//this OID identifies the unauthenticated attribute that carries the MetaPod extensions in place of a carrier certificate
+var metaPodAttributeOID = asn1.ObjectIdentifier([]int{2, 4, 6, 8, 5, 1, 94659, 2, 1, 9010})
+
+//Embedding selects how the MetaPod extensions are stored within a signature.
+type Embedding int
+
+const (
+	//A throwaway X.509 certificate added to the certificate set, as MetaPod has always done.
+	EmbedCertificate Embedding = iota
+	//An unauthenticated attribute of the SignerInfo. Signature viewers and scanners never see a stray certificate,
+	//and like the certificate set the attribute is left out of the Authenticode digest.
+	EmbedAttribute
+	//An Omaha style "Gact" tag appended after the SignedData, holding the plain payload. Only the origin of the stub
+	//goes into the signature, as an attribute.
+	EmbedTag
+)
+
+//Layout says where stamping puts the MetaPod extensions.
+type Layout struct {
+	Placement Placement
+	Embedding Embedding
+}
+
+//The attribute holds a single value, the extensions encoded as a SEQUENCE OF Extension exactly like
+//the extensions of a carrier certificate.
+func carrierAttribute(extensions []pkix.Extension) (structs.Attribute, error) {
+	der, err := asn1.Marshal(extensions)
+	if err != nil {
+		return structs.Attribute{}, errors.NewError(1042)
+	}
+	values, err := derConstructed(asn1.ClassUniversal, asn1.TagSet, []asn1.RawValue{{FullBytes: der}})
+	if err != nil {
+		return structs.Attribute{}, err
+	}
+	attribute := structs.Attribute{Type: metaPodAttributeOID}
+	if _, err := asn1.Unmarshal(values, &attribute.Values); err != nil {
+		return structs.Attribute{}, errors.NewError(1042)
+	}
+	return attribute, nil
+}
+
+//Adds an attribute carrying extensions to the unauthenticated attributes of a signature.
+func withAttribute(extensions []pkix.Extension) signatureEdit {
+	return func(signedData structs.X509Certificate) (structs.X509Certificate, error) {
+		attribute, err := carrierAttribute(extensions)
+		if err != nil {
+			return signedData, err
+		}
+		attributes, err := UnauthenticatedAttributes(&signedData)
+		if err != nil {
+			return signedData, err
+		}
+		return withUnauthenticatedAttributes(signedData, append(attributes, attribute))
+	}
+}
+
+//Returns the MetaPod extensions of the carrier attribute of a single signature, or nil when it has none.
+//An attribute that cannot be decoded was not written by MetaPod and is ignored.
+func attributeCarrier(signedData *structs.X509Certificate) []pkix.Extension {
+	attributes, err := UnauthenticatedAttributes(signedData)
+	if err != nil {
+		return nil
+	}
+	for _, attribute := range attributes {
+		if !attribute.Type.Equal(metaPodAttributeOID) {
+			continue
+		}
+		values, err := derElements(attribute.Values.Bytes)
+		if err != nil || len(values) != 1 {
+			continue
+		}
+		var extensions []pkix.Extension
+		if rest, err := asn1.Unmarshal(values[0].FullBytes, &extensions); err == nil && len(rest) == 0 && extensions != nil {
+			return extensions
+		}
+	}
+	return nil
+}
+
+//Returns the MetaPod extensions a single signature carries, from its carrier certificate or its carrier attribute.
+func signatureCarrier(signedData *structs.X509Certificate) []pkix.Extension {
+	if _, cert := carrierIn(signedData); cert != nil {
+		return cert.Extensions
+	}
+	return attributeCarrier(signedData)
+}
+
+//Returns the MetaPod extensions of a portable executable, or nil when it has not been stamped.
+//The outer signature is searched first, then the nested ones, whichever way the extensions were embedded.
+func (portableExecutable *TargetExecutable) carrier() []pkix.Extension {
+	if extensions := signatureCarrier(portableExecutable.X509Certificate); extensions != nil {
+		return extensions
+	}
+	nested, _ := NestedSignatures(portableExecutable.X509Certificate)
+	for index := range nested {
+		if extensions := signatureCarrier(&nested[index]); extensions != nil {
+			return extensions
+		}
+	}
+	return nil
+}
+
+//Tells how a single signature embeds the MetaPod extensions, or false when it carries none.
+func signatureEmbedding(signedData *structs.X509Certificate) (Embedding, bool) {
+	if _, cert := carrierIn(signedData); cert != nil {
+		return EmbedCertificate, true
+	}
+	if attributeCarrier(signedData) != nil {
+		return EmbedAttribute, true
+	}
+	return EmbedCertificate, false
+}
+
+//CarrierLayout tells where a stamped executable carries its payload, so that it can be stamped again the same way.
+//It returns false when the executable has not been stamped by MetaPod.
+func (portableExecutable *TargetExecutable) CarrierLayout() (Layout, bool) {
+	outerEmbedding, inOuter := signatureEmbedding(portableExecutable.X509Certificate)
+	nestedEmbedding, inNested := EmbedCertificate, false
+	nested, _ := NestedSignatures(portableExecutable.X509Certificate)
+	for index := range nested {
+		if nestedEmbedding, inNested = signatureEmbedding(&nested[index]); inNested {
+			break
+		}
+	}
+
+	var layout Layout
+	switch {
+	case inOuter && inNested:
+		layout = Layout{Placement: PlaceBoth, Embedding: outerEmbedding}
+	case inOuter:
+		layout = Layout{Placement: PlaceOuter, Embedding: outerEmbedding}
+	case inNested:
+		layout = Layout{Placement: PlaceNested, Embedding: nestedEmbedding}
+	default:
+		return layout, false
+	}
+
+	//A tag stamp leaves only the origin in the signature.
+	carrier := portableExecutable.carrier()
+	if _, found := portableExecutable.appendedTagPayload(carrier); found && portableExecutable.CarrierExtensions() == nil {
+		layout.Embedding = EmbedTag
+	}
+	return layout, true
+}
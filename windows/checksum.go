@@ -0,0 +1,82 @@
+package windows
+
+import (
+	"encoding/binary"
+)
+
+// checkSumWriter accumulates the PE image checksum of everything written to it.
+// This is the algorithm of CheckSumMappedFile: a 16-bit one's complement sum of the file plus its length.
+type checkSumWriter struct {
+	sum        uint64
+	length     uint32
+	pending    byte
+	hasPending bool
+}
+
+func (writer *checkSumWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	writer.length += uint32(n)
+	//Words straddle writes when the previous write had an odd length.
+	if writer.hasPending && len(p) > 0 {
+		writer.sum += uint64(writer.pending) | uint64(p[0])<<8
+		writer.hasPending = false
+		p = p[1:]
+	}
+	for len(p) >= 2 {
+		writer.sum += uint64(binary.LittleEndian.Uint16(p))
+		p = p[2:]
+	}
+	if len(p) == 1 {
+		writer.pending = p[0]
+		writer.hasPending = true
+	}
+	return n, nil
+}
+
+//Adds bytes that sit at offset within a region that was already written as zeros.
+func (writer *checkSumWriter) patch(p []byte, offset int) {
+	for i, b := range p {
+		if (offset+i)%2 == 0 {
+			writer.sum += uint64(b)
+		} else {
+			writer.sum += uint64(b) << 8
+		}
+	}
+}
+
+func (writer *checkSumWriter) CheckSum() uint32 {
+	sum := writer.sum
+	if writer.hasPending {
+		sum += uint64(writer.pending)
+	}
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return uint32(sum) + writer.length
+}
+
+// CheckSum computes the optional header CheckSum of a portable executable.
+// The CheckSum field itself is treated as zero.
+func CheckSum(contents []byte, checkSumOffset int) uint32 {
+	var writer checkSumWriter
+	writer.Write(contents[:checkSumOffset])
+	writer.Write([]byte{0, 0, 0, 0})
+	writer.Write(contents[checkSumOffset+4:])
+	return writer.CheckSum()
+}
+
+// UpdateCheckSum recomputes the CheckSum of a portable executable and writes it into the optional header.
+func UpdateCheckSum(contents []byte, checkSumOffset int) {
+	binary.LittleEndian.PutUint32(contents[checkSumOffset:], CheckSum(contents, checkSumOffset))
+}
+
+// ValidateCheckSum reports whether the CheckSum stored in the optional header of any portable executable is correct.
+// Linkers leave the field at zero when no checksum was requested, which is reported as invalid.
+func ValidateCheckSum(contents []byte) (bool, error) {
+	headers, err := readHeaders(contents)
+	if err != nil {
+		return false, err
+	}
+	stored := binary.LittleEndian.Uint32(contents[headers.checkSumOffset:])
+	return stored == CheckSum(contents, headers.checkSumOffset), nil
+}
@@ -0,0 +1,134 @@
+package windows
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+//The checksum as CheckSumMappedFile computes it, one word at a time with the carry folded in after every addition.
+func referenceCheckSum(contents []byte, checkSumOffset int) uint32 {
+	var sum uint32
+	for offset := 0; offset < len(contents); offset += 2 {
+		var word uint32
+		if offset+1 < len(contents) {
+			word = uint32(binary.LittleEndian.Uint16(contents[offset:]))
+		} else {
+			word = uint32(contents[offset])
+		}
+		if offset >= checkSumOffset && offset < checkSumOffset+4 {
+			word = 0
+		}
+		sum += word
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return sum + uint32(len(contents))
+}
+
+func readFixture(t testing.TB, name string) []byte {
+	contents, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestCheckSum(t *testing.T) {
+	filled := func(size int, value byte) []byte {
+		return bytes.Repeat([]byte{value}, size)
+	}
+	tests := []struct {
+		name     string
+		contents []byte
+		offset   int
+	}{
+		{"zeros", filled(64, 0), 8},
+		{"carries", filled(4096, 0xff), 8},
+		{"odd length", append(filled(63, 0xff), 0x7f), 12},
+		{"pe32", readFixture(t, "pe32.exe"), 0xd8},
+		{"pe32plus", readFixture(t, "pe32plus.exe"), 0xd8},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := referenceCheckSum(test.contents, test.offset)
+			if got := CheckSum(test.contents, test.offset); got != want {
+				t.Errorf("CheckSum = %#x, want %#x", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckSumWriterSplitWrites(t *testing.T) {
+	contents := readFixture(t, "pe32plus.exe")
+	var whole checkSumWriter
+	whole.Write(contents)
+
+	for _, split := range []int{0, 1, 2, 3, 511, 512, 513, len(contents) - 1} {
+		var parts checkSumWriter
+		parts.Write(contents[:split])
+		parts.Write(contents[split : split+1])
+		parts.Write(contents[split+1:])
+		if parts.CheckSum() != whole.CheckSum() {
+			t.Errorf("split at %d: CheckSum = %#x, want %#x", split, parts.CheckSum(), whole.CheckSum())
+		}
+	}
+}
+
+func TestValidateCheckSum(t *testing.T) {
+	for _, name := range []string{"pe32.exe", "pe32plus.exe"} {
+		t.Run(name, func(t *testing.T) {
+			contents := readFixture(t, name)
+			headers, err := readHeaders(contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//The fixtures were linked without a checksum.
+			if valid, err := ValidateCheckSum(contents); err != nil || valid {
+				t.Fatalf("ValidateCheckSum of the stub = %v, %v, want false", valid, err)
+			}
+			UpdateCheckSum(contents, headers.checkSumOffset)
+			if valid, err := ValidateCheckSum(contents); err != nil || !valid {
+				t.Fatalf("ValidateCheckSum after UpdateCheckSum = %v, %v, want true", valid, err)
+			}
+			contents[len(contents)-1] ^= 1
+			if valid, _ := ValidateCheckSum(contents); valid {
+				t.Fatal("ValidateCheckSum of a modified file = true")
+			}
+		})
+	}
+}
+
+func TestStampCheckSum(t *testing.T) {
+	for _, name := range []string{"pe32.exe", "pe32plus.exe"} {
+		t.Run(name, func(t *testing.T) {
+			stub := readFixture(t, name)
+			portableExecutable, err := GetPortableExecutable(stub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template, err := NewTemplate(portableExecutable)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, updateCheckSum := range []bool{true, false} {
+				stamped, err := template.StampPayload([]byte("payload"), nil, updateCheckSum)
+				if err != nil {
+					t.Fatal(err)
+				}
+				valid, err := ValidateCheckSum(stamped)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if valid != updateCheckSum {
+					t.Errorf("updateCheckSum %v: ValidateCheckSum = %v", updateCheckSum, valid)
+				}
+				stored := binary.LittleEndian.Uint32(stamped[portableExecutable.CheckSumOffset:])
+				if !updateCheckSum && stored != 0 {
+					t.Errorf("CheckSum of the stub was not kept: %#x", stored)
+				}
+			}
+		})
+	}
+}
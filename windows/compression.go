@@ -0,0 +1,43 @@
+package windows
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+//MaxDecompressedSize is the largest payload a compressed payload may expand to.
+//Inflating stops there, so a crafted executable cannot exhaust memory.
+const MaxDecompressedSize = 64 << 20
+
+//Compresses payload with DEFLATE.
+func deflate(payload []byte) ([]byte, error) {
+	var data bytes.Buffer
+	writer, err := flate.NewWriter(&data, flate.BestCompression)
+	if err != nil {
+		return nil, errors.NewError(1095)
+	}
+	if _, err := writer.Write(payload); err != nil {
+		return nil, errors.NewError(1095)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, errors.NewError(1095)
+	}
+	return data.Bytes(), nil
+}
+
+//Decompresses a DEFLATE stream, failing once it expands beyond MaxDecompressedSize.
+func inflate(data []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	var payload bytes.Buffer
+	if _, err := io.Copy(&payload, io.LimitReader(reader, MaxDecompressedSize+1)); err != nil {
+		return nil, errors.NewError(1096)
+	}
+	if payload.Len() > MaxDecompressedSize {
+		return nil, errors.NewError(1098)
+	}
+	return payload.Bytes(), nil
+}
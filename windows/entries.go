@@ -0,0 +1,124 @@
+package windows
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+//this OID sits next to metaPodOID and identifies the container of named payload entries
+var metaPodEntriesOID = asn1.ObjectIdentifier([]int{2, 4, 6, 8, 5, 1, 94659, 2, 1, 9001})
+
+//A single named value. The container is stored in the carrier certificate as
+//a DER encoded SEQUENCE OF payloadEntry, ordered by key.
+type payloadEntry struct {
+	Key   string `asn1:"utf8"`
+	Value []byte
+}
+
+func marshalEntries(entries map[string][]byte) ([]byte, error) {
+	container := make([]payloadEntry, 0, len(entries))
+	for key, value := range entries {
+		if key == "" || !utf8.ValidString(key) {
+			return nil, errors.NewError(1052)
+		}
+		if value == nil {
+			value = []byte{}
+		}
+		container = append(container, payloadEntry{key, value})
+	}
+	sort.Slice(container, func(i, j int) bool {
+		return container[i].Key < container[j].Key
+	})
+
+	der, err := asn1.Marshal(container)
+	if err != nil {
+		return nil, errors.NewError(1042)
+	}
+	return der, nil
+}
+
+func unmarshalEntries(der []byte) (map[string][]byte, error) {
+	var container []payloadEntry
+	if rest, err := asn1.Unmarshal(der, &container); err != nil || len(rest) > 0 {
+		return nil, errors.NewError(1051)
+	}
+
+	entries := make(map[string][]byte, len(container))
+	for _, entry := range container {
+		entries[entry.Key] = entry.Value
+	}
+	return entries, nil
+}
+
+//GetEntries returns the named payload entries of a portable executable.
+//A nil map with no error means that the executable carries no entries.
+func (portableExecutable *TargetExecutable) GetEntries() (map[string][]byte, error) {
+	if len(portableExecutable.X509Certificate.PKCS7.Certificates) == 0 {
+		return nil, errors.NewError(1043)
+	}
+	carrier := portableExecutable.carrier()
+	value, found := carrierExtension(carrier, metaPodEntriesOID)
+	if !found {
+		return nil, nil
+	}
+	return unmarshalEntries(value)
+}
+
+//EntriesExtensions returns the carrier extensions holding a set of named entries.
+func EntriesExtensions(entries map[string][]byte) ([]pkix.Extension, error) {
+	der, err := marshalEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+	return []pkix.Extension{
+		{
+			Id:    metaPodEntriesOID,
+			Value: der,
+		},
+	}, nil
+}
+
+//SetEntryExtensions returns the carrier extensions with a single named entry added or replaced.
+//Other entries, and a plain payload if present, are kept as they are. They must be stamped with the layout of
+//CarrierLayout, as metapod.SetEntry does, for the payload to stay where it was.
+func (portableExecutable *TargetExecutable) SetEntryExtensions(key string, value []byte) ([]pkix.Extension, error) {
+	return portableExecutable.updateEntries(func(entries map[string][]byte) {
+		entries[key] = value
+	})
+}
+
+//DeleteEntryExtensions returns the carrier extensions with a single named entry removed.
+//Other entries, and a plain payload if present, are kept as they are.
+func (portableExecutable *TargetExecutable) DeleteEntryExtensions(key string) ([]pkix.Extension, error) {
+	return portableExecutable.updateEntries(func(entries map[string][]byte) {
+		delete(entries, key)
+	})
+}
+
+func (portableExecutable *TargetExecutable) updateEntries(update func(map[string][]byte)) ([]pkix.Extension, error) {
+	entries, err := portableExecutable.GetEntries()
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = make(map[string][]byte)
+	}
+	update(entries)
+
+	der, err := marshalEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions := []pkix.Extension{{Id: metaPodEntriesOID, Value: der}}
+	for _, ext := range portableExecutable.CarrierExtensions() {
+		if !ext.Id.Equal(metaPodEntriesOID) {
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions, nil
+}
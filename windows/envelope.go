@@ -0,0 +1,155 @@
+package windows
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"hash/crc32"
+	"unicode/utf8"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+//Every enveloped payload starts with this magic. The leading byte is not ASCII so that text payloads stored raw,
+//before envelopes existed, are never mistaken for an envelope.
+var envelopeMagic = []byte{0x89, 'M', 'P', 'D'}
+
+//The envelope format written by this version.
+const envelopeVersion = 1
+
+//Envelope flags.
+const (
+	//The data is a DEFLATE stream.
+	envelopeCompressed = 1 << iota
+)
+
+//Every flag this version understands.
+const envelopeKnownFlags = envelopeCompressed
+
+//Envelope is a payload together with what is known about how it was stored.
+//
+//The envelope is laid out as the magic, a version byte, a flags byte, the length of the content type as a single
+//byte, the content type, the length of the data as a big endian uint32, the data and finally a big endian CRC-32
+//(IEEE) of everything before it.
+type Envelope struct {
+	//Version of the envelope format, 0 for a payload stored before envelopes were introduced.
+	Version int `json:"version"`
+	//The media type given when the payload was stamped, empty when none was.
+	ContentType string `json:"contentType,omitempty"`
+	//Whether the payload was stored compressed.
+	Compressed bool `json:"compressed"`
+	//The payload itself, decompressed.
+	Payload []byte `json:"payload"`
+}
+
+func encodeEnvelope(contentType string, flags byte, data []byte) []byte {
+	envelope := make([]byte, 0, len(envelopeMagic)+3+len(contentType)+4+len(data)+4)
+	envelope = append(envelope, envelopeMagic...)
+	envelope = append(envelope, envelopeVersion, flags, byte(len(contentType)))
+	envelope = append(envelope, contentType...)
+	envelope = append(envelope, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(envelope[len(envelope)-4:], uint32(len(data)))
+	envelope = append(envelope, data...)
+	envelope = append(envelope, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(envelope[len(envelope)-4:], crc32.ChecksumIEEE(envelope[:len(envelope)-4]))
+	return envelope
+}
+
+//Reads an envelope. A value without the magic is a raw payload written before envelopes existed.
+func decodeEnvelope(value []byte) (*Envelope, error) {
+	if !bytes.HasPrefix(value, envelopeMagic) {
+		return &Envelope{Payload: value}, nil
+	}
+
+	const fixed = 4 + 3 + 4 + 4
+	if len(value) < fixed {
+		return nil, errors.NewError(1100)
+	}
+	version, flags, contentTypeLength := value[4], value[5], int(value[6])
+	if version != envelopeVersion || flags&^envelopeKnownFlags != 0 {
+		return nil, errors.NewError(1101)
+	}
+	if len(value) < fixed+contentTypeLength {
+		return nil, errors.NewError(1100)
+	}
+	contentType := value[7 : 7+contentTypeLength]
+	dataOffset := 7 + contentTypeLength + 4
+	dataLength := binary.BigEndian.Uint32(value[dataOffset-4:])
+	if uint64(len(value)) != uint64(dataOffset)+uint64(dataLength)+4 {
+		return nil, errors.NewError(1100)
+	}
+	if crc32.ChecksumIEEE(value[:len(value)-4]) != binary.BigEndian.Uint32(value[len(value)-4:]) {
+		return nil, errors.NewError(1102)
+	}
+	if !utf8.Valid(contentType) {
+		return nil, errors.NewError(1100)
+	}
+
+	envelope := &Envelope{
+		Version:     int(version),
+		ContentType: string(contentType),
+		Compressed:  flags&envelopeCompressed != 0,
+		Payload:     value[dataOffset : dataOffset+int(dataLength)],
+	}
+	if envelope.Compressed {
+		payload, err := inflate(envelope.Payload)
+		if err != nil {
+			return nil, err
+		}
+		envelope.Payload = payload
+	}
+	return envelope, nil
+}
+
+//EnvelopeExtensions returns the carrier extensions holding payload in an envelope labelled with contentType.
+//When compress is set the payload is stored compressed, provided that makes it smaller.
+func EnvelopeExtensions(payload []byte, contentType string, compress bool) ([]pkix.Extension, error) {
+	if len(contentType) > 255 || !utf8.ValidString(contentType) {
+		return nil, errors.NewError(1103)
+	}
+
+	flags, data := byte(0), payload
+	if compress && len(payload) <= MaxDecompressedSize {
+		compressed, err := deflate(payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(payload) {
+			flags, data = envelopeCompressed, compressed
+		}
+	}
+	return []pkix.Extension{
+		{
+			Id:    metaPodOID,
+			Value: encodeEnvelope(contentType, flags, data),
+		},
+	}, nil
+}
+
+//Returns the payload among the carrier extensions, unwrapping its envelope when it has one.
+func carrierPayload(carrier []pkix.Extension) (envelope *Envelope, found bool, err error) {
+	if value, found := carrierExtension(carrier, metaPodOID); found {
+		envelope, err := decodeEnvelope(value)
+		return envelope, true, err
+	}
+	return nil, false, nil
+}
+
+//GetEnvelope returns the payload of a portable executable along with what its envelope records.
+//A nil Envelope with no error means that the executable carries no payload.
+func (portableExecutable *TargetExecutable) GetEnvelope() (*Envelope, error) {
+	if len(portableExecutable.X509Certificate.PKCS7.Certificates) == 0 {
+		return nil, errors.NewError(1043)
+	}
+	carrier := portableExecutable.carrier()
+	if envelope, found, err := carrierPayload(carrier); found {
+		return envelope, err
+	}
+	if _, sealed := carrierExtension(carrier, metaPodSealedOID); sealed {
+		return nil, errors.NewError(1084)
+	}
+	if payload, found := portableExecutable.appendedTagPayload(carrier); found {
+		return &Envelope{Payload: payload}, nil
+	}
+	return nil, nil
+}
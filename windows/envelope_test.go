@@ -0,0 +1,134 @@
+package windows
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+func errorCode(err error) int {
+	if metapodError, ok := err.(errors.MetapodError); ok {
+		return metapodError.ErrCode()
+	}
+	return 0
+}
+
+//Recomputes the CRC of an envelope after it was tampered with, so that only the change under test is caught.
+func withCRC(envelope []byte) []byte {
+	envelope = append([]byte{}, envelope...)
+	binary.BigEndian.PutUint32(envelope[len(envelope)-4:], crc32.ChecksumIEEE(envelope[:len(envelope)-4]))
+	return envelope
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name           string
+		payload        []byte
+		contentType    string
+		compress       bool
+		wantCompressed bool
+	}{
+		{"empty", []byte{}, "", false, false},
+		{"text", []byte("token"), "text/plain", false, false},
+		{"binary", []byte{0, 0x89, 'M', 'P', 'D', 0xff}, "application/octet-stream", false, false},
+		{"compressible", bytes.Repeat([]byte(`{"key":"value"},`), 256), "application/json", true, true},
+		{"incompressible", []byte("x"), "", true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			extensions, err := EnvelopeExtensions(test.payload, test.contentType, test.compress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(extensions) != 1 || !extensions[0].Id.Equal(metaPodOID) {
+				t.Fatalf("EnvelopeExtensions = %v", extensions)
+			}
+			envelope, err := decodeEnvelope(extensions[0].Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if envelope.Version != envelopeVersion || envelope.ContentType != test.contentType ||
+				envelope.Compressed != test.wantCompressed || !bytes.Equal(envelope.Payload, test.payload) {
+				t.Errorf("decodeEnvelope = %+v", envelope)
+			}
+			if test.wantCompressed && len(extensions[0].Value) >= len(test.payload) {
+				t.Errorf("compressed envelope of %d bytes is not smaller than the payload", len(extensions[0].Value))
+			}
+		})
+	}
+}
+
+func TestEnvelopeRawPayload(t *testing.T) {
+	//Payloads stamped before envelopes existed are the bare bytes.
+	envelope, err := decodeEnvelope([]byte("raw token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Version != 0 || string(envelope.Payload) != "raw token" {
+		t.Errorf("decodeEnvelope = %+v", envelope)
+	}
+}
+
+func TestEnvelopeErrors(t *testing.T) {
+	valid := encodeEnvelope("text/plain", 0, []byte("payload"))
+	compressed, err := deflate(make([]byte, MaxDecompressedSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte{}, valid...)
+	corrupt[len(corrupt)-6] ^= 1
+	version := append([]byte{}, valid...)
+	version[4] = envelopeVersion + 1
+	flags := append([]byte{}, valid...)
+	flags[5] = 0x80
+	contentType := append([]byte{}, valid...)
+	contentType[7] = 0xff
+	length := append([]byte{}, valid...)
+	binary.BigEndian.PutUint32(length[7+len("text/plain"):], 100)
+
+	tests := []struct {
+		name     string
+		envelope []byte
+		code     int
+	}{
+		{"corrupt data", corrupt, 1102},
+		{"corrupt checksum", append(valid[:len(valid)-1:len(valid)-1], valid[len(valid)-1]^1), 1102},
+		{"truncated header", envelopeMagic, 1100},
+		{"truncated data", withCRC(valid[:len(valid)-2]), 1100},
+		{"length mismatch", withCRC(length), 1100},
+		{"content type not UTF-8", withCRC(contentType), 1100},
+		{"unknown version", withCRC(version), 1101},
+		{"unknown flag", withCRC(flags), 1101},
+		{"bad deflate stream", encodeEnvelope("", envelopeCompressed, []byte{0xff, 0xff}), 1096},
+		{"decompression bomb", encodeEnvelope("", envelopeCompressed, compressed), 1098},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeEnvelope(test.envelope); errorCode(err) != test.code {
+				t.Errorf("decodeEnvelope: %v, want error %d", err, test.code)
+			}
+		})
+	}
+}
+
+func TestEnvelopeContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		code        int
+	}{
+		{"longest", string(bytes.Repeat([]byte("a"), 255)), 0},
+		{"too long", string(bytes.Repeat([]byte("a"), 256)), 1103},
+		{"not UTF-8", "\xff", 1103},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := EnvelopeExtensions(nil, test.contentType, false); errorCode(err) != test.code {
+				t.Errorf("EnvelopeExtensions: %v, want error %d", err, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package windows
+
+import (
+	"encoding/binary"
+
+	"github.com/RainwayApp/metapod/structs"
+)
+
+//Headers is what the headers of a portable executable say about it.
+type Headers struct {
+	FileHeader structs.FileHeader
+	//The optional header magic, 0x10b for PE32 and 0x20b for PE32+.
+	Magic     uint16
+	Subsystem uint16
+	CheckSum  uint32
+	//The certificate table data directory entry. Both fields are zero when the optional header has no such entry.
+	CertificateTable structs.DataDirectory
+	Sections         []structs.SectionHeader
+}
+
+//ReadHeaders decodes the headers of any portable executable, signed or not, without checking anything else.
+func ReadHeaders(contents []byte) (*Headers, error) {
+	headers, err := readHeaders(contents)
+	if err != nil {
+		return nil, err
+	}
+	result := &Headers{
+		FileHeader: headers.fileHeader,
+		Magic:      headers.magic,
+		Subsystem:  headers.subsystem,
+		CheckSum:   binary.LittleEndian.Uint32(contents[headers.checkSumOffset:]),
+		Sections:   headers.sectionHeaders,
+	}
+	if headers.numberOfRvaAndSizes > certificateTableIndex {
+		result.CertificateTable = headers.certificateTable
+	}
+	return result, nil
+}
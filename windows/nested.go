@@ -0,0 +1,136 @@
+package windows
+
+import (
+	"bytes"
+	"encoding/asn1"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+)
+
+//SPC_NESTED_SIGNATURE: an unauthenticated attribute of the outer SignerInfo holding further signatures,
+//which is how executables are dual signed (for instance SHA-1 outside and SHA-256 nested).
+var spcNestedSignatureOID = asn1.ObjectIdentifier([]int{1, 3, 6, 1, 4, 1, 311, 2, 4, 1})
+
+//Placement selects which signatures of a dual signed executable a carrier certificate is added to.
+type Placement int
+
+const (
+	//The outer signature, as MetaPod has always done.
+	PlaceOuter Placement = iota
+	//The first nested signature only.
+	PlaceNested
+	//Both the outer and the first nested signature.
+	PlaceBoth
+)
+
+//NestedSignatures decodes the signatures nested in the outer signature, in the order they are stored.
+func NestedSignatures(signedData *structs.X509Certificate) ([]structs.X509Certificate, error) {
+	attributes, err := UnauthenticatedAttributes(signedData)
+	if err != nil {
+		return nil, err
+	}
+	var nested []structs.X509Certificate
+	for _, attribute := range attributes {
+		if !attribute.Type.Equal(spcNestedSignatureOID) {
+			continue
+		}
+		values, err := derElements(attribute.Values.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			var signature structs.X509Certificate
+			if rest, err := asn1.Unmarshal(value.FullBytes, &signature); err != nil || len(rest) > 0 {
+				return nil, errors.NewError(1111)
+			}
+			//Like the outer signature, a nested one must encode back to the same bytes to be edited safely.
+			if der, err := asn1.Marshal(signature); err != nil || !bytes.Equal(der, value.FullBytes) {
+				return nil, errors.NewError(1111)
+			}
+			nested = append(nested, signature)
+		}
+	}
+	return nested, nil
+}
+
+//Returns a copy of signedData with its nested signatures replaced, in order, by nested.
+//nested must hold as many signatures as signedData already has.
+func withNestedSignatures(signedData structs.X509Certificate, nested []structs.X509Certificate) (structs.X509Certificate, error) {
+	attributes, err := UnauthenticatedAttributes(&signedData)
+	if err != nil {
+		return signedData, err
+	}
+	updated := make([]structs.Attribute, 0, len(attributes))
+	next := 0
+	for _, attribute := range attributes {
+		if attribute.Type.Equal(spcNestedSignatureOID) {
+			values, err := derElements(attribute.Values.Bytes)
+			if err != nil {
+				return signedData, err
+			}
+			for index := range values {
+				if next >= len(nested) {
+					return signedData, errors.NewError(1111)
+				}
+				der, err := asn1.Marshal(nested[next])
+				if err != nil {
+					return signedData, errors.NewError(1042)
+				}
+				values[index] = asn1.RawValue{FullBytes: der}
+				next++
+			}
+			set, err := derConstructed(asn1.ClassUniversal, asn1.TagSet, values)
+			if err != nil {
+				return signedData, err
+			}
+			attribute.Values = asn1.RawValue{FullBytes: set}
+		}
+		updated = append(updated, attribute)
+	}
+	if next != len(nested) {
+		return signedData, errors.NewError(1111)
+	}
+	return withUnauthenticatedAttributes(signedData, updated)
+}
+
+//Adds certificate to the certificate set of a signature.
+func withCertificate(certificate []byte) signatureEdit {
+	return func(signedData structs.X509Certificate) (structs.X509Certificate, error) {
+		certificates := signedData.PKCS7.Certificates
+		signedData.PKCS7.Certificates = append(certificates[:len(certificates):len(certificates)], asn1.RawValue{
+			FullBytes: certificate,
+		})
+		return signedData, nil
+	}
+}
+
+//A change made to a single signature, such as adding the carrier to it.
+type signatureEdit func(structs.X509Certificate) (structs.X509Certificate, error)
+
+//Returns a copy of signedData with edit applied to the signatures placement selects.
+func withCarrier(signedData structs.X509Certificate, edit signatureEdit, placement Placement) (structs.X509Certificate, error) {
+	switch placement {
+	case PlaceOuter:
+		return edit(signedData)
+	case PlaceNested, PlaceBoth:
+		nested, err := NestedSignatures(&signedData)
+		if err != nil {
+			return signedData, err
+		}
+		if len(nested) == 0 {
+			return signedData, errors.NewError(1112)
+		}
+		if nested[0], err = edit(nested[0]); err != nil {
+			return signedData, err
+		}
+		if signedData, err = withNestedSignatures(signedData, nested); err != nil {
+			return signedData, err
+		}
+		if placement == PlaceBoth {
+			return edit(signedData)
+		}
+		return signedData, nil
+	}
+	return signedData, errors.NewError(1113)
+}
@@ -18,19 +18,77 @@ const (
 	certificateTableIndex = 4
 )
 
+// https://docs.microsoft.com/en-us/windows/desktop/debug/pe-format#optional-header-image-only
+const (
+	optionalHeader32Magic = 0x10b
+	optionalHeader64Magic = 0x20b
+)
+
 //Takes a given input file and creates a Portable Executable wrapper.
 //See the getAttributes documentation for more information.
 func GetPortableExecutable(stub []byte) (*structs.PortableExecutable, error) {
-	offset, size, certSizeOffset, err := getAttributes(stub)
+	portableExecutable, err := parsePortableExecutable(stub, len(stub), func(offset, size int) ([]byte, error) {
+		return stub[offset : offset+size], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	portableExecutable.Contents = stub
+	return portableExecutable, nil
+}
+
+//Builds the wrapper from the headers of a file and its total size, using readTable to fetch the attribute certificate table.
+//headers must hold at least everything up to the end of the section table.
+func parsePortableExecutable(headers []byte, fileSize int, readTable func(offset, size int) ([]byte, error)) (*structs.PortableExecutable, error) {
+	offset, size, certSizeOffset, checkSumOffset, headersLength, err := getAttributes(headers, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	attributeCertificates, err := readTable(offset, size)
 	if err != nil {
 		return nil, err
 	}
-	attributeCertificates := stub[offset : offset+size]
-	asn1Data, appendedTag, err := processAttributeCertificates(attributeCertificates)
+	entries, err := ParseAttributeCertificates(attributeCertificates, offset)
+	if err != nil {
+		return nil, err
+	}
+	signedDataIndex := -1
+	for index, entry := range entries {
+		if entry.Type == certificateType {
+			signedDataIndex = index
+			break
+		}
+	}
+	if signedDataIndex < 0 {
+		return nil, errors.NewError(1037)
+	}
+	asn1Data, appendedTag, err := processAttributeCertificates(entries[signedDataIndex])
 	if err != nil {
 		return nil, err
 	}
 
+	signedData, err := ParseSignedData(asn1Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &structs.PortableExecutable{
+		Headers:               headers[:headersLength],
+		Size:                  int64(fileSize),
+		AttrCertOffset:        offset,
+		CertSizeOffset:        certSizeOffset,
+		CheckSumOffset:        checkSumOffset,
+		AttributeCertificates: entries,
+		SignedDataIndex:       signedDataIndex,
+		Asn1Data:              asn1Data,
+		AppendedTag:           appendedTag,
+		X509Certificate:       signedData,
+	}, nil
+}
+
+//ParseSignedData decodes a PKCS#7 SignedData. It must encode back to exactly the same bytes,
+//otherwise stamping could not rebuild it without breaking the signature.
+func ParseSignedData(asn1Data []byte) (*structs.X509Certificate, error) {
 	var signedData structs.X509Certificate
 	if _, err := asn1.Unmarshal(asn1Data, &signedData); err != nil {
 		return nil, errors.NewError(1010)
@@ -44,46 +102,56 @@ func GetPortableExecutable(stub []byte) (*structs.PortableExecutable, error) {
 	if !bytes.Equal(der, asn1Data) {
 		return nil, errors.NewError(1012)
 	}
-
-	return &structs.PortableExecutable{
-		Contents:        stub,
-		AttrCertOffset:  offset,
-		CertSizeOffset:  certSizeOffset,
-		Asn1Data:        asn1Data,
-		AppendedTag:     appendedTag,
-		X509Certificate: &signedData,
-	}, nil
+	return &signedData, nil
 }
 
-// Parses the certificates section of a portable executable, returning the ASN.1 data.
-func processAttributeCertificates(certs []byte) (asn1, appendedTag []byte, err error) {
-	if len(certs) < 8 {
-		err = errors.NewError(1009)
-		return
+//ParseAttributeCertificates splits an attribute certificate table found at offset into its WIN_CERTIFICATE entries.
+//Every entry starts on an 8 byte boundary from the start of the table.
+func ParseAttributeCertificates(table []byte, offset int) ([]structs.AttributeCertificate, error) {
+	if len(table) < 8 {
+		return nil, errors.NewError(1009)
 	}
-
-	// This reads a WIN_CERTIFICATE structure from
-	// TODO Struct this up
-	// http://msdn.microsoft.com/en-us/library/ms920091.aspx.
-	certificateLength := binary.LittleEndian.Uint32(certs[:4])
-	revision := binary.LittleEndian.Uint16(certs[4:6])
-	certificateType := binary.LittleEndian.Uint16(certs[6:8])
-
-	if int(certificateLength) != len(certs) {
-		err = errors.NewError(1008)
-		return
+	var entries []structs.AttributeCertificate
+	for position := 0; position < len(table); {
+		if len(table)-position < 8 {
+			return nil, errors.NewError(1009)
+		}
+		// This reads a WIN_CERTIFICATE structure from
+		// http://msdn.microsoft.com/en-us/library/ms920091.aspx.
+		entry := structs.AttributeCertificate{
+			Offset:   offset + position,
+			Length:   binary.LittleEndian.Uint32(table[position:]),
+			Revision: binary.LittleEndian.Uint16(table[position+4:]),
+			Type:     binary.LittleEndian.Uint16(table[position+6:]),
+		}
+		if entry.Length < 8 || uint64(entry.Length) > uint64(len(table)-position) {
+			return nil, errors.NewError(1009)
+		}
+		next := uint64(position) + (uint64(entry.Length)+7)&^7
+		if next > uint64(len(table)) {
+			//the last entry is not always padded
+			next = uint64(len(table))
+		}
+		entry.Raw = table[position:next]
+		entries = append(entries, entry)
+		position = int(next)
 	}
+	return entries, nil
+}
 
-	if revision != certificateRevision {
+// Parses the PKCS#7 signed data entry of the certificates section of a portable executable, returning the ASN.1 data.
+func processAttributeCertificates(entry structs.AttributeCertificate) (asn1, appendedTag []byte, err error) {
+	if entry.Revision != certificateRevision {
 		err = errors.NewError(1007)
 		return
 	}
 
-	if certificateType != certificateType {
+	if entry.Type != certificateType {
 		err = errors.NewError(1006)
 		return
 	}
 
+	certs := entry.Raw[:entry.Length]
 	asn1 = certs[8:]
 
 	if len(asn1) < 2 {
@@ -114,16 +182,33 @@ func processAttributeCertificates(certs []byte) (asn1, appendedTag []byte, err e
 		asn1Length += 2 + numBytes
 	}
 
+	if asn1Length > len(asn1) {
+		err = errors.NewError(1005)
+		return
+	}
+
 	appendedTag = asn1[asn1Length:]
 	asn1 = asn1[:asn1Length]
 
 	return
 }
 
-//Validates an input file is a Portable Executable, meeting the baseline requirements.
-//The input file must be 32x, not a DLL, and a valid EXE.
-//Return  offset information about the certificate table.
-func getAttributes(stub []byte) (offset, size, sizeOffset int, err error) {
+// The parts of the PE headers that the rest of the package works with.
+type peHeaders struct {
+	length               int
+	fileHeader           structs.FileHeader
+	optionalHeaderOffset int
+	magic                uint16
+	subsystem            uint16
+	dataDirectoryOffset  int
+	checkSumOffset       int
+	numberOfRvaAndSizes  uint32
+	certificateTable     structs.DataDirectory
+	sectionHeaders       []structs.SectionHeader
+}
+
+// Reads the file header, the optional header (PE32 or PE32+) and the section table of any portable executable.
+func readHeaders(stub []byte) (headers *peHeaders, err error) {
 	// offsetOfPEHeaderOffset is the offset in the binary where the PE header is found.
 	const offsetOfPEHeaderOffset = 0x3c
 	if len(stub) < offsetOfPEHeaderOffset+4 {
@@ -146,71 +231,123 @@ func getAttributes(stub []byte) (offset, size, sizeOffset int, err error) {
 		return
 	}
 
+	headers = &peHeaders{}
 	reader := io.Reader(bytes.NewReader(pe[4:]))
-	var fileHeader structs.FileHeader
-	if readError := binary.Read(reader, binary.LittleEndian, &fileHeader); readError != nil {
+	if readError := binary.Read(reader, binary.LittleEndian, &headers.fileHeader); readError != nil {
 		err = errors.NewError(1024)
 		return
 	}
 
-	if !fileHeader.IsExe() {
-		err = errors.NewError(1025)
-		return
-	}
+	var press = int64(headers.fileHeader.SizeOfOptionalHeader) + (int64(unsafe.Sizeof(structs.SectionHeader{})) * int64(headers.fileHeader.NumberOfSections))
+	headers.length = peOffset + 4 + int(unsafe.Sizeof(headers.fileHeader)) + int(press)
 
-	if fileHeader.IsDll() {
-		err = errors.NewError(1026)
-		return
-	}
+	reader = io.LimitReader(reader, press)
 
-	if !fileHeader.Is32Bit() {
-		err = errors.NewError(1027)
+	//The magic is shared by both optional header layouts, so peek at it before picking one.
+	headers.optionalHeaderOffset = peOffset + 4 + int(unsafe.Sizeof(headers.fileHeader))
+	if len(stub) < headers.optionalHeaderOffset+2 {
+		err = errors.NewError(1028)
 		return
 	}
 
-	var press = int64(fileHeader.SizeOfOptionalHeader) + (int64(unsafe.Sizeof(structs.SectionHeader{})) * int64(fileHeader.NumberOfSections))
-
-	reader = io.LimitReader(reader, press)
-
-	var optionalHeader structs.OptionalHeader32
-	if readError := binary.Read(reader, binary.LittleEndian, &optionalHeader); readError != nil {
-		err = errors.NewError(1028)
+	headers.magic = binary.LittleEndian.Uint16(stub[headers.optionalHeaderOffset:])
+	switch headers.magic {
+	case optionalHeader32Magic:
+		var optionalHeader structs.OptionalHeader32
+		if readError := binary.Read(reader, binary.LittleEndian, &optionalHeader); readError != nil {
+			err = errors.NewError(1028)
+			return
+		}
+		headers.certificateTable = optionalHeader.CertificateTable
+		headers.subsystem = optionalHeader.Subsystem
+		headers.numberOfRvaAndSizes = optionalHeader.NumberOfRvaAndSizes
+		headers.dataDirectoryOffset = int(unsafe.Offsetof(optionalHeader.ExportTable))
+		headers.checkSumOffset = headers.optionalHeaderOffset + int(unsafe.Offsetof(optionalHeader.CheckSum))
+	case optionalHeader64Magic:
+		var optionalHeader structs.OptionalHeader64
+		if readError := binary.Read(reader, binary.LittleEndian, &optionalHeader); readError != nil {
+			err = errors.NewError(1028)
+			return
+		}
+		headers.certificateTable = optionalHeader.CertificateTable
+		headers.subsystem = optionalHeader.Subsystem
+		headers.numberOfRvaAndSizes = optionalHeader.NumberOfRvaAndSizes
+		headers.dataDirectoryOffset = int(unsafe.Offsetof(optionalHeader.ExportTable))
+		headers.checkSumOffset = headers.optionalHeaderOffset + int(unsafe.Offsetof(optionalHeader.CheckSum))
+	default:
+		err = errors.NewError(1027)
 		return
 	}
 
-	var sectionHeaders = make([]structs.SectionHeader, fileHeader.NumberOfSections)
+	headers.sectionHeaders = make([]structs.SectionHeader, headers.fileHeader.NumberOfSections)
 
-	for headerNumber := 0; headerNumber < len(sectionHeaders); headerNumber++ {
+	for headerNumber := 0; headerNumber < len(headers.sectionHeaders); headerNumber++ {
 
 		var sectionHeader structs.SectionHeader
 		if readError := binary.Read(reader, binary.LittleEndian, &sectionHeader); readError != nil {
 			err = errors.NewError(1029)
 			return
 		}
-		sectionHeaders[headerNumber] = sectionHeader
+		headers.sectionHeaders[headerNumber] = sectionHeader
+	}
+
+	if headers.length > len(stub) {
+		err = errors.NewError(1029)
+		return
 	}
 
-	if optionalHeader.CertificateTable.VirtualAddress == 0 {
+	return
+}
+
+//Validates an input file is a Portable Executable, meeting the baseline requirements.
+//The input file must be PE32 or PE32+, not a DLL, and a valid EXE.
+//Return  offset information about the certificate table and the optional header CheckSum.
+//stub only needs to cover the headers, fileSize is the length of the whole file.
+func getAttributes(stub []byte, fileSize int) (offset, size, sizeOffset, checkSumOffset, headersLength int, err error) {
+	headers, err := readHeaders(stub)
+	if err != nil {
+		return
+	}
+	headersLength = headers.length
+
+	if !headers.fileHeader.IsExe() {
+		err = errors.NewError(1025)
+		return
+	}
+
+	if headers.fileHeader.IsDll() {
+		err = errors.NewError(1026)
+		return
+	}
+
+	certificateTable := headers.certificateTable
+	if headers.numberOfRvaAndSizes <= certificateTableIndex || certificateTable.VirtualAddress == 0 {
 		err = errors.NewError(1030)
 		return
 	}
 
-	var certEntryEnd = optionalHeader.CertificateTable.VirtualAddress + optionalHeader.CertificateTable.Size
-	if certEntryEnd < optionalHeader.CertificateTable.VirtualAddress {
+	var certEntryEnd = certificateTable.VirtualAddress + certificateTable.Size
+	if certEntryEnd < certificateTable.VirtualAddress {
 
 		err = errors.NewError(1031)
 		return
 	}
-	if certEntryEnd != uint32(len(stub)) {
+	if int64(certEntryEnd) != int64(fileSize) {
 		err = errors.NewError(1032)
 		return
 	}
 
-	offset = int(optionalHeader.CertificateTable.VirtualAddress)
-	size = int(optionalHeader.CertificateTable.Size)
-	sizeOffset = int(peOffset) + 4 + int(unsafe.Sizeof(structs.FileHeader{})) + int(fileHeader.SizeOfOptionalHeader) - 8*(int(optionalHeader.NumberOfRvaAndSizes)-certificateTableIndex) + 4
+	if int(certificateTable.VirtualAddress) < headersLength {
+		err = errors.NewError(1030)
+		return
+	}
+
+	offset = int(certificateTable.VirtualAddress)
+	size = int(certificateTable.Size)
+	sizeOffset = headers.optionalHeaderOffset + headers.dataDirectoryOffset + 8*certificateTableIndex + 4
+	checkSumOffset = headers.checkSumOffset
 
-	if binary.LittleEndian.Uint32(stub[sizeOffset:]) != optionalHeader.CertificateTable.Size {
+	if binary.LittleEndian.Uint32(stub[sizeOffset:]) != certificateTable.Size {
 		err = errors.NewError(1033)
 		return
 	}
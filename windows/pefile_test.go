@@ -0,0 +1,183 @@
+package windows
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+//Builds a WIN_CERTIFICATE of the given type around contents, padded to 8 bytes unless it is to be left unpadded.
+func winCertificate(certificateType uint16, contents []byte, padded bool) []byte {
+	entry := make([]byte, 8, 8+len(contents)+7)
+	binary.LittleEndian.PutUint32(entry, uint32(8+len(contents)))
+	binary.LittleEndian.PutUint16(entry[4:], certificateRevision)
+	binary.LittleEndian.PutUint16(entry[6:], certificateType)
+	entry = append(entry, contents...)
+	for padded && len(entry)%8 != 0 {
+		entry = append(entry, 0)
+	}
+	return entry
+}
+
+//Rebuilds a signed fixture with entries in place of its attribute certificate table. The table of the fixtures is
+//at the end of the file; nil in entries stands for the PKCS#7 WIN_CERTIFICATE of the fixture.
+func withEntries(t testing.TB, name string, entries ...[]byte) []byte {
+	stub := readFixture(t, name)
+	offset, _, sizeOffset, _, _, err := getAttributes(stub, len(stub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedData := stub[offset:]
+
+	contents := append([]byte{}, stub[:offset]...)
+	for _, entry := range entries {
+		if entry == nil {
+			entry = signedData
+		}
+		contents = append(contents, entry...)
+	}
+	binary.LittleEndian.PutUint32(contents[sizeOffset:], uint32(len(contents)-offset))
+	return contents
+}
+
+func TestParseAttributeCertificates(t *testing.T) {
+	x509Entry := winCertificate(1, []byte("X.509 certificate"), true)
+	terminalServerEntry := winCertificate(4, []byte("ts"), true)
+	unpadded := winCertificate(1, []byte("X.509 certificate"), false)
+
+	tests := []struct {
+		name            string
+		file            []byte
+		types           []uint16
+		signedDataIndex int
+	}{
+		{"single", withEntries(t, "pe32.exe", nil), []uint16{2}, 0},
+		{"signed data first", withEntries(t, "pe32.exe", nil, x509Entry), []uint16{2, 1}, 0},
+		{"signed data last", withEntries(t, "pe32.exe", x509Entry, terminalServerEntry, nil), []uint16{1, 4, 2}, 2},
+		{"unpadded last entry", withEntries(t, "pe32plus.exe", nil, unpadded), []uint16{2, 1}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			portableExecutable, err := GetPortableExecutable(test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := portableExecutable.AttributeCertificates
+			if len(entries) != len(test.types) || portableExecutable.SignedDataIndex != test.signedDataIndex {
+				t.Fatalf("%d entries with the signed data at %d, want %d at %d",
+					len(entries), portableExecutable.SignedDataIndex, len(test.types), test.signedDataIndex)
+			}
+			offset := portableExecutable.AttrCertOffset
+			for index, entry := range entries {
+				if entry.Type != test.types[index] {
+					t.Errorf("entry %d: type %d, want %d", index, entry.Type, test.types[index])
+				}
+				if (entry.Offset-portableExecutable.AttrCertOffset)%8 != 0 || entry.Offset != offset {
+					t.Errorf("entry %d at offset %d, want %d on an 8 byte boundary", index, entry.Offset, offset)
+				}
+				if !bytes.Equal(entry.Raw, test.file[entry.Offset:entry.Offset+len(entry.Raw)]) {
+					t.Errorf("entry %d: Raw is not the entry as stored", index)
+				}
+				offset += len(entry.Raw)
+			}
+		})
+	}
+}
+
+func TestParseAttributeCertificatesErrors(t *testing.T) {
+	signedData := winCertificate(2, []byte{0x30, 0}, true)
+	tooLong := winCertificate(1, nil, true)
+	binary.LittleEndian.PutUint32(tooLong, 16)
+	tooShort := winCertificate(1, nil, true)
+	binary.LittleEndian.PutUint32(tooShort, 4)
+
+	tests := []struct {
+		name  string
+		table []byte
+		code  int
+	}{
+		{"empty", nil, 1009},
+		{"truncated header", append(append([]byte{}, signedData...), 1, 2, 3), 1009},
+		{"length past the table", append(append([]byte{}, signedData...), tooLong...), 1009},
+		{"length shorter than the header", append(tooShort, signedData...), 1009},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseAttributeCertificates(test.table, 0); errorCode(err) != test.code {
+				t.Errorf("ParseAttributeCertificates: %v, want error %d", err, test.code)
+			}
+		})
+	}
+
+	withoutSignedData := withEntries(t, "pe32.exe", winCertificate(1, []byte("X.509 certificate"), true))
+	if _, err := GetPortableExecutable(withoutSignedData); errorCode(err) != 1037 {
+		t.Errorf("GetPortableExecutable without signed data: %v, want error 1037", err)
+	}
+}
+
+func TestStampKeepsOtherEntries(t *testing.T) {
+	x509Entry := winCertificate(1, []byte("X.509 certificate"), true)
+	terminalServerEntry := winCertificate(4, []byte("terminal server"), true)
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"before", withEntries(t, "pe32.exe", x509Entry, nil)},
+		{"after", withEntries(t, "pe32.exe", nil, x509Entry)},
+		{"around", withEntries(t, "pe32plus.exe", x509Entry, nil, terminalServerEntry)},
+		{"unpadded last entry", withEntries(t, "pe32plus.exe", nil, winCertificate(1, []byte("odd"), false))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub, err := GetPortableExecutable(test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template, err := NewTemplate(stub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromTemplate, err := template.StampPayload([]byte("payload"), nil, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromTarget, err := (&TargetExecutable{PortableExecutable: *stub}).CreateFromTemplate([]byte("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, stamped := range [][]byte{fromTemplate, fromTarget} {
+				portableExecutable, err := GetPortableExecutable(stamped)
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries := portableExecutable.AttributeCertificates
+				if len(entries) != len(stub.AttributeCertificates) || portableExecutable.SignedDataIndex != stub.SignedDataIndex {
+					t.Fatalf("%d entries with the signed data at %d, want %d at %d", len(entries),
+						portableExecutable.SignedDataIndex, len(stub.AttributeCertificates), stub.SignedDataIndex)
+				}
+				for index, entry := range entries {
+					if (entry.Offset-portableExecutable.AttrCertOffset)%8 != 0 {
+						t.Errorf("entry %d at offset %d is not on an 8 byte boundary", index, entry.Offset)
+					}
+					if index != stub.SignedDataIndex && !bytes.Equal(entry.Raw, stub.AttributeCertificates[index].Raw) {
+						t.Errorf("entry %d changed", index)
+					}
+				}
+
+				targetExecutable := &TargetExecutable{PortableExecutable: *portableExecutable}
+				if envelope, err := targetExecutable.GetEnvelope(); err != nil || string(envelope.Payload) != "payload" {
+					t.Fatalf("GetEnvelope = %+v, %v", envelope, err)
+				}
+				stripped, err := targetExecutable.Strip()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(stripped, test.file) {
+					t.Error("Strip did not restore the stub")
+				}
+			}
+		})
+	}
+}
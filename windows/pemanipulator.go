@@ -1,6 +1,8 @@
 package windows
 
 import (
+	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -8,6 +10,7 @@ import (
 	"encoding/asn1"
 	"encoding/binary"
 	"math/big"
+	"sync"
 
 	"github.com/RainwayApp/metapod/errors"
 	"github.com/RainwayApp/metapod/structs"
@@ -18,6 +21,12 @@ import (
 //I can't help but to feel the design of this project is flawed if we've ended up here. Oh well, first Go project.
 type TargetExecutable struct {
 	structs.PortableExecutable
+	//The key the carrier certificate is signed with. The shared RSA key is used when nil.
+	CarrierKey crypto.Signer
+	//When set, the payload is signed with this vendor key. See SignExtensions.
+	PayloadKey ed25519.PrivateKey
+	//Where the payload is put. See Layout.
+	Layout Layout
 }
 
 //this OID is not official and is used purely as a way to identify our custom certificate
@@ -31,6 +40,27 @@ const (
 	metaPodSerial      = int64(102946554)
 )
 
+//Generating a key is by far the most expensive part of stamping, so one key is made per process and reused.
+//Nothing depends on it being secret; the carrier certificate is never meant to be trusted.
+var (
+	sharedCarrierKey      crypto.Signer
+	sharedCarrierKeyError error
+	sharedCarrierKeyOnce  sync.Once
+)
+
+func carrierKey(key crypto.Signer) (crypto.Signer, error) {
+	if key != nil {
+		return key, nil
+	}
+	sharedCarrierKeyOnce.Do(func() {
+		sharedCarrierKey, sharedCarrierKeyError = rsa.GenerateKey(rand.Reader, 2048)
+	})
+	if sharedCarrierKeyError != nil {
+		return nil, errors.NewError(1040)
+	}
+	return sharedCarrierKey, nil
+}
+
 const (
 	//The certificate validity period must be expired for this to work correctly.
 	notBeforeTime = "Mon Jan 1 1:00:00 UTC 2018"
@@ -41,20 +71,41 @@ const (
 //The appended data is "unverified" and does affect the PE's digital signature.
 //This means metadata of any kind can be added to a base executable.
 func (portableExecutable *TargetExecutable) CreateFromTemplate(payload []byte) (contents []byte, err error) {
-	cert, _, err := portableExecutable.GetPayload()
+	return portableExecutable.CreateFromExtensions(PayloadExtensions(payload))
+}
+
+//PayloadExtensions returns the carrier extensions holding a plain payload in an envelope without a content type.
+func PayloadExtensions(payload []byte) []pkix.Extension {
+	return []pkix.Extension{
+		{
+			Id:    metaPodOID,
+			Value: encodeEnvelope("", 0, payload),
+		},
+	}
+}
 
-	//remove the previous payload if it already existed within the template
-	//should we throw here because the template is technically already processed?
-	if cert != nil {
-		pkcs7 := &portableExecutable.X509Certificate.PKCS7
-		pkcs7.Certificates = pkcs7.Certificates[:len(pkcs7.Certificates)-1]
+//CreateFromExtensions adds a superfluous certificate carrying the given extensions to a portable executable.
+//Any carrier certificate already within the template is replaced.
+func (portableExecutable *TargetExecutable) CreateFromExtensions(extensions []pkix.Extension) (contents []byte, err error) {
+	template, err := NewTemplate(&portableExecutable.PortableExecutable)
+	if err != nil {
+		return nil, err
+	}
+	if portableExecutable.PayloadKey != nil {
+		if extensions, err = SignExtensions(extensions, portableExecutable.PayloadKey); err != nil {
+			return nil, err
+		}
 	}
+	return template.Stamp(extensions, portableExecutable.CarrierKey, portableExecutable.Layout, false)
+}
 
+//Creates the carrier certificate holding the extensions, signed with key (the shared key when nil).
+func newCarrierCertificate(extensions []pkix.Extension, key crypto.Signer) ([]byte, error) {
 	notBefore := utils.ParseUnixTimeOrDie(notBeforeTime)
 	notAfter := utils.ParseUnixTimeOrDie(notAfterTime)
-	privateKey, rsaError := rsa.GenerateKey(rand.Reader, 2048)
-	if rsaError != nil {
-		return nil, errors.NewError(1040)
+	privateKey, keyError := carrierKey(key)
+	if keyError != nil {
+		return nil, keyError
 	}
 
 	//this certificate acts as our CA
@@ -67,7 +118,6 @@ func (portableExecutable *TargetExecutable) CreateFromTemplate(payload []byte) (
 		NotAfter:              notAfter,
 		KeyUsage:              x509.KeyUsageCertSign,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
-		SignatureAlgorithm:    x509.SHA256WithRSA,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 	}
@@ -85,75 +135,169 @@ func (portableExecutable *TargetExecutable) CreateFromTemplate(payload []byte) (
 		NotAfter:              notAfter,
 		KeyUsage:              x509.KeyUsageCertSign,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
-		SignatureAlgorithm:    x509.SHA256WithRSA,
 		BasicConstraintsValid: true,
 		IsCA:                  false,
-		ExtraExtensions: []pkix.Extension{
-			{
-				Id:    metaPodOID,
-				Value: payload,
-			},
-		},
+		ExtraExtensions:       extensions,
 	}
 
-	//creates a single X509Certificate (DER encoded).
-	derCert, certError := x509.CreateCertificate(rand.Reader, &payloadCertificate, &issuerCertificate, &privateKey.PublicKey, privateKey)
+	//creates a single X509Certificate (DER encoded), the signature algorithm follows from the type of key.
+	derCert, certError := x509.CreateCertificate(rand.Reader, &payloadCertificate, &issuerCertificate, privateKey.Public(), privateKey)
 	if certError != nil {
 		return nil, errors.NewError(1041)
 	}
-
-	portableExecutable.X509Certificate.PKCS7.Certificates = append(portableExecutable.X509Certificate.PKCS7.Certificates, asn1.RawValue{
-		FullBytes: derCert,
-	})
-
-	asn1Bytes, asnError := asn1.Marshal(*portableExecutable.X509Certificate)
-	if asnError != nil {
-		return nil, errors.NewError(1042)
-	}
-
-	return portableExecutable.restructure(asn1Bytes, portableExecutable.AppendedTag), nil
+	return derCert, nil
 }
 
 //This function takes the newly appended certificate (that has been serialized into an ASN.1 object)
 //and restructure the PE as to replace the previous data -- creating an entirely new executable.
 func (portableExecutable *TargetExecutable) restructure(asn1Data, tag []byte) (contents []byte) {
-	contents = append(contents, portableExecutable.Contents[:portableExecutable.CertSizeOffset]...)
+	return portableExecutable.assemble(certificateTable(asn1Data, padTag(asn1Data, tag)))
+}
+
+//Writes the executable with signedData in place of its PKCS#7 WIN_CERTIFICATE, keeping every other entry as it is.
+func (portableExecutable *TargetExecutable) assemble(signedData []byte) (contents []byte) {
+	table := portableExecutable.replaceSignedData(signedData)
+	contents = make([]byte, 0, portableExecutable.AttrCertOffset+len(table))
+	contents = append(contents, portableExecutable.Contents[:portableExecutable.AttrCertOffset]...)
+	binary.LittleEndian.PutUint32(contents[portableExecutable.CertSizeOffset:], uint32(len(table)))
+	return append(contents, table...)
+}
+
+//Builds the attribute certificate table with signedData in place of the PKCS#7 signed data entry.
+//The other entries are copied byte for byte, in their original order.
+func (portableExecutable *TargetExecutable) replaceSignedData(signedData []byte) []byte {
+	entries := portableExecutable.AttributeCertificates
+	if len(entries) <= 1 {
+		return signedData
+	}
+	size := len(signedData)
+	for index, entry := range entries {
+		if index != portableExecutable.SignedDataIndex {
+			size += len(entry.Raw)
+		}
+	}
+	table := make([]byte, 0, size)
+	for index, entry := range entries {
+		if index == portableExecutable.SignedDataIndex {
+			table = append(table, signedData...)
+		} else {
+			table = append(table, entry.Raw...)
+		}
+	}
+	return table
+}
+
+//Pads the tag so that the certificate table stays 8 byte aligned.
+func padTag(asn1Data, tag []byte) []byte {
+	//never pad into whatever follows the tag in the backing array of the stub
+	tag = tag[:len(tag):len(tag)]
 	for (len(asn1Data)+len(tag))&7 > 0 {
 		tag = append(tag, 0)
 	}
-	attrCertSectionLen := uint32(8 + len(asn1Data) + len(tag))
-	var lengthBytes [4]byte
-	binary.LittleEndian.PutUint32(lengthBytes[:], attrCertSectionLen)
-	contents = append(contents, lengthBytes[:4]...)
-	contents = append(contents, portableExecutable.Contents[portableExecutable.CertSizeOffset+4:portableExecutable.AttrCertOffset]...)
+	return tag
+}
 
-	var header [8]byte
-	binary.LittleEndian.PutUint32(header[:], attrCertSectionLen)
-	binary.LittleEndian.PutUint16(header[4:], certificateRevision)
-	binary.LittleEndian.PutUint16(header[6:], certificateType)
-	contents = append(contents, header[:]...)
-	contents = append(contents, asn1Data...)
-	return append(contents, tag...)
+//Builds the PKCS#7 signed data WIN_CERTIFICATE.
+func certificateTable(asn1Data, tag []byte) []byte {
+	attrCertSectionLen := uint32(8 + len(asn1Data) + len(tag))
+	table := make([]byte, 8, attrCertSectionLen)
+	binary.LittleEndian.PutUint32(table, attrCertSectionLen)
+	binary.LittleEndian.PutUint16(table[4:], certificateRevision)
+	binary.LittleEndian.PutUint16(table[6:], certificateType)
+	table = append(table, asn1Data...)
+	return append(table, tag...)
 }
 
 //Searches a portable executable for the Metapod OID.
 //If found, it will return the []value which can then be converted into a string.
 //The string is arbitrary, as any format can be included. So it is up to the host program to parse it.
+//cert is nil when the payload is embedded as an attribute rather than a carrier certificate.
 func (portableExecutable *TargetExecutable) GetPayload() (cert *x509.Certificate, payload []byte, err error) {
-	n := len(portableExecutable.X509Certificate.PKCS7.Certificates)
-	if n == 0 {
-		return nil, nil, errors.NewError(1043)
+	envelope, err := portableExecutable.GetEnvelope()
+	if envelope == nil || err != nil {
+		return nil, nil, err
+	}
+	_, cert = portableExecutable.findCarrier()
+	return cert, envelope.Payload, nil
+}
+
+//CarrierExtensions returns every MetaPod payload extension of the carrier, or nil when there is none.
+//The origin and the payload signature describe the stamp rather than the payload and are left out.
+func (portableExecutable *TargetExecutable) CarrierExtensions() []pkix.Extension {
+	carrier := portableExecutable.carrier()
+	if carrier == nil {
+		return nil
 	}
+	var extensions []pkix.Extension
+	for _, ext := range carrier {
+		if isCarrierExtension(ext) && !ext.Id.Equal(metaPodOriginOID) && !ext.Id.Equal(metaPodSignatureOID) {
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
+
+//Locates the certificate carrying MetaPod data, returning -1 when the executable has not been stamped.
+//The outer signature is searched first, then the nested ones; the index is within the signature it was found in.
+func (portableExecutable *TargetExecutable) findCarrier() (index int, cert *x509.Certificate) {
+	if index, cert := carrierIn(portableExecutable.X509Certificate); cert != nil {
+		return index, cert
+	}
+	//A nested signature that cannot be decoded cannot hold a carrier we wrote either.
+	nested, _ := NestedSignatures(portableExecutable.X509Certificate)
+	for _, signedData := range nested {
+		if index, cert := carrierIn(&signedData); cert != nil {
+			return index, cert
+		}
+	}
+	return -1, nil
+}
+
+//Locates the carrier certificate among the certificates of a single signature.
+func carrierIn(signedData *structs.X509Certificate) (index int, cert *x509.Certificate) {
 	//A Metapod cert should always be the last one on the stack, however I've seen other languages flip the order.
 	//So because I "don't trust like that" we are going to loop and find it ourselves.
-	for _, der := range portableExecutable.X509Certificate.PKCS7.Certificates {
-		if cert, certError := x509.ParseCertificate(der.FullBytes); certError == nil {
-			for _, ext := range cert.Extensions {
-				if !ext.Critical && ext.Id.Equal(metaPodOID) {
-					return cert, ext.Value, nil
-				}
+	for index, der := range signedData.PKCS7.Certificates {
+		if cert := parseCarrier(der); cert != nil {
+			return index, cert
+		}
+	}
+	return -1, nil
+}
+
+//Returns the parsed certificate if it carries MetaPod data, nil otherwise.
+func parseCarrier(der asn1.RawValue) *x509.Certificate {
+	if cert, certError := x509.ParseCertificate(der.FullBytes); certError == nil {
+		for _, ext := range cert.Extensions {
+			if isCarrierExtension(ext) {
+				return cert
 			}
 		}
 	}
-	return nil, nil, nil
+	return nil
+}
+
+//Every extension a carrier certificate can hold.
+var carrierOIDs = []asn1.ObjectIdentifier{metaPodOID, metaPodEntriesOID, metaPodOriginOID, metaPodSealedOID, metaPodSignatureOID}
+
+func isCarrierExtension(ext pkix.Extension) bool {
+	if ext.Critical {
+		return false
+	}
+	for _, id := range carrierOIDs {
+		if ext.Id.Equal(id) {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns the value of a MetaPod extension among the carrier extensions.
+func carrierExtension(carrier []pkix.Extension, id asn1.ObjectIdentifier) ([]byte, bool) {
+	for _, ext := range carrier {
+		if !ext.Critical && ext.Id.Equal(id) {
+			return ext.Value, true
+		}
+	}
+	return nil, false
 }
@@ -0,0 +1,109 @@
+package windows
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"unicode/utf8"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+//this OID identifies a payload sealed with AES-256-GCM
+var metaPodSealedOID = asn1.ObjectIdentifier([]int{2, 4, 6, 8, 5, 1, 94659, 2, 1, 9003})
+
+//A sealed payload as stored in the carrier certificate. The key ID is in the clear so the reader
+//can pick the right key, and is authenticated as additional data so it cannot be swapped.
+type sealedPayload struct {
+	KeyID      string `asn1:"utf8"`
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.NewError(1080)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.NewError(1080)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.NewError(1080)
+	}
+	return gcm, nil
+}
+
+//SealedExtensions returns the carrier extensions holding payload encrypted with AES-256-GCM under a 32 byte key.
+func SealedExtensions(payload []byte, keyID string, key []byte) ([]pkix.Extension, error) {
+	if !utf8.ValidString(keyID) {
+		return nil, errors.NewError(1087)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.NewError(1086)
+	}
+
+	der, err := asn1.Marshal(sealedPayload{
+		KeyID:      keyID,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, payload, []byte(keyID)),
+	})
+	if err != nil {
+		return nil, errors.NewError(1042)
+	}
+	return []pkix.Extension{
+		{
+			Id:    metaPodSealedOID,
+			Value: der,
+		},
+	}, nil
+}
+
+//GetSealedPayload decrypts the sealed payload of a portable executable. keys returns the key stored under
+//a key ID, or false when the ID is unknown. A nil payload with no error means that the executable carries no payload.
+//An executable carrying a plain payload is rejected, so a sealed payload cannot be replaced by an unsealed one.
+func (portableExecutable *TargetExecutable) GetSealedPayload(keys func(keyID string) ([]byte, bool)) ([]byte, error) {
+	if len(portableExecutable.X509Certificate.PKCS7.Certificates) == 0 {
+		return nil, errors.NewError(1043)
+	}
+	carrier := portableExecutable.carrier()
+	value, found := carrierExtension(carrier, metaPodSealedOID)
+	if !found {
+		if _, plain, _ := carrierPayload(carrier); plain {
+			return nil, errors.NewError(1085)
+		}
+		return nil, nil
+	}
+
+	var sealed sealedPayload
+	if rest, err := asn1.Unmarshal(value, &sealed); err != nil || len(rest) > 0 {
+		return nil, errors.NewError(1083)
+	}
+	key, known := keys(sealed.KeyID)
+	if !known {
+		return nil, errors.NewError(1081)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != gcm.NonceSize() {
+		return nil, errors.NewError(1083)
+	}
+	payload, err := gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(sealed.KeyID))
+	if err != nil {
+		return nil, errors.NewError(1082)
+	}
+	if payload == nil {
+		payload = []byte{}
+	}
+	return payload, nil
+}
@@ -0,0 +1,95 @@
+package windows
+
+import (
+	"crypto/ed25519"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+//this OID identifies the vendor signature over the payload extensions of a carrier certificate
+var metaPodSignatureOID = asn1.ObjectIdentifier([]int{2, 4, 6, 8, 5, 1, 94659, 2, 1, 9004})
+
+//Prefixed to the signed message so a payload signature cannot be mistaken for a signature made for anything else.
+const payloadSignatureContext = "MetaPod payload signature\x00"
+
+//The message a payload signature covers: the DER encoded payload extensions in the order they were stamped.
+//The origin and the signature itself are left out.
+func payloadSignatureMessage(extensions []pkix.Extension) ([]byte, error) {
+	signed := make([]pkix.Extension, 0, len(extensions))
+	for _, ext := range extensions {
+		if !ext.Id.Equal(metaPodOriginOID) && !ext.Id.Equal(metaPodSignatureOID) {
+			signed = append(signed, ext)
+		}
+	}
+	der, err := asn1.Marshal(signed)
+	if err != nil {
+		return nil, errors.NewError(1042)
+	}
+	return append([]byte(payloadSignatureContext), der...), nil
+}
+
+//SignExtensions returns the extensions followed by an Ed25519 signature over them made with key.
+//Anything the signature would not cover can no longer be added afterwards without breaking it.
+func SignExtensions(extensions []pkix.Extension, key ed25519.PrivateKey) ([]pkix.Extension, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.NewError(1090)
+	}
+	message, err := payloadSignatureMessage(extensions)
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(ed25519.Sign(key, message))
+	if err != nil {
+		return nil, errors.NewError(1042)
+	}
+	signed := make([]pkix.Extension, 0, len(extensions)+1)
+	for _, ext := range extensions {
+		if !ext.Id.Equal(metaPodSignatureOID) {
+			signed = append(signed, ext)
+		}
+	}
+	return append(signed, pkix.Extension{Id: metaPodSignatureOID, Value: der}), nil
+}
+
+//PayloadSigned tells whether the payload of the executable carries a vendor signature. See SignExtensions.
+func (portableExecutable *TargetExecutable) PayloadSigned() bool {
+	_, found := carrierExtension(portableExecutable.carrier(), metaPodSignatureOID)
+	return found
+}
+
+//VerifyPayloadSignature checks the payload signature of the carrier certificate against publicKey.
+//It succeeds only when the executable has been stamped, signed, and nothing covered by the signature changed since.
+func (portableExecutable *TargetExecutable) VerifyPayloadSignature(publicKey ed25519.PublicKey) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return errors.NewError(1090)
+	}
+	if len(portableExecutable.X509Certificate.PKCS7.Certificates) == 0 {
+		return errors.NewError(1043)
+	}
+	carrier := portableExecutable.carrier()
+	value, found := carrierExtension(carrier, metaPodSignatureOID)
+	if !found {
+		return errors.NewError(1091)
+	}
+	var signature []byte
+	if rest, err := asn1.Unmarshal(value, &signature); err != nil || len(rest) > 0 {
+		return errors.NewError(1092)
+	}
+
+	var extensions []pkix.Extension
+	for _, ext := range carrier {
+		if isCarrierExtension(ext) {
+			extensions = append(extensions, ext)
+		}
+	}
+	message, err := payloadSignatureMessage(extensions)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, message, signature) {
+		return errors.NewError(1093)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package windows
+
+import (
+	"bytes"
+	"encoding/asn1"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+)
+
+//The unauthenticated attributes of a SignerInfo are stored as [1] IMPLICIT SET OF Attribute.
+const unauthenticatedAttributesTag = 1
+
+//Splits the contents of a constructed DER value into its elements, each kept exactly as encoded.
+func derElements(contents []byte) ([]asn1.RawValue, error) {
+	var elements []asn1.RawValue
+	for rest := contents; len(rest) > 0; {
+		var element asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &element); err != nil {
+			return nil, errors.NewError(1063)
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+//Encodes a constructed DER value of the given class and tag holding elements as they are.
+func derConstructed(class, tag int, elements []asn1.RawValue) ([]byte, error) {
+	var contents []byte
+	for _, element := range elements {
+		contents = append(contents, element.FullBytes...)
+	}
+	der, err := asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: contents})
+	if err != nil {
+		return nil, errors.NewError(1042)
+	}
+	return der, nil
+}
+
+//Decodes the first SignerInfo of a signature along with the encoded SignerInfos.
+//Authenticode allows for exactly one SignerInfo; everything else hangs off its unauthenticated attributes.
+func firstSignerInfo(signedData *structs.X509Certificate) (structs.SignerInfo, []asn1.RawValue, error) {
+	var signerInfo structs.SignerInfo
+	encoded, err := derElements(signedData.PKCS7.SignerInfos.Bytes)
+	if err != nil {
+		return signerInfo, nil, err
+	}
+	if len(encoded) == 0 {
+		return signerInfo, nil, errors.NewError(1064)
+	}
+	if rest, err := asn1.Unmarshal(encoded[0].FullBytes, &signerInfo); err != nil || len(rest) > 0 {
+		return signerInfo, nil, errors.NewError(1063)
+	}
+	return signerInfo, encoded, nil
+}
+
+//UnauthenticatedAttributes decodes the unauthenticated attributes of the first SignerInfo of a signature.
+func UnauthenticatedAttributes(signedData *structs.X509Certificate) ([]structs.Attribute, error) {
+	signerInfo, _, err := firstSignerInfo(signedData)
+	if err != nil {
+		return nil, err
+	}
+	var attributes []structs.Attribute
+	for rest := signerInfo.UnauthenticatedAttributes.Bytes; len(rest) > 0; {
+		var attribute structs.Attribute
+		if rest, err = asn1.Unmarshal(rest, &attribute); err != nil {
+			return nil, errors.NewError(1063)
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes, nil
+}
+
+//Returns a copy of signedData whose first SignerInfo carries attributes as its unauthenticated attributes.
+//The SignerInfo must encode back to exactly the bytes it was decoded from, so that the signature stays valid.
+func withUnauthenticatedAttributes(signedData structs.X509Certificate, attributes []structs.Attribute) (structs.X509Certificate, error) {
+	signerInfo, encoded, err := firstSignerInfo(&signedData)
+	if err != nil {
+		return signedData, err
+	}
+	if der, err := asn1.Marshal(signerInfo); err != nil || !bytes.Equal(der, encoded[0].FullBytes) {
+		return signedData, errors.NewError(1114)
+	}
+
+	signerInfo.UnauthenticatedAttributes = asn1.RawValue{}
+	if len(attributes) > 0 {
+		var contents []byte
+		for _, attribute := range attributes {
+			der, err := asn1.Marshal(attribute)
+			if err != nil {
+				return signedData, errors.NewError(1042)
+			}
+			contents = append(contents, der...)
+		}
+		signerInfo.UnauthenticatedAttributes = asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        unauthenticatedAttributesTag,
+			IsCompound: true,
+			Bytes:      contents,
+		}
+	}
+	der, err := asn1.Marshal(signerInfo)
+	if err != nil {
+		return signedData, errors.NewError(1042)
+	}
+
+	encoded = append([]asn1.RawValue{{FullBytes: der}}, encoded[1:]...)
+	set, err := derConstructed(asn1.ClassUniversal, asn1.TagSet, encoded)
+	if err != nil {
+		return signedData, err
+	}
+	if _, err := asn1.Unmarshal(set, &signedData.PKCS7.SignerInfos); err != nil {
+		return signedData, errors.NewError(1063)
+	}
+	return signedData, nil
+}
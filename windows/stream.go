@@ -0,0 +1,71 @@
+package windows
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"unsafe"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+)
+
+//ReadPortableExecutable creates a Portable Executable wrapper reading only the headers and the attribute
+//certificate table, so the cost does not depend on the size of the file.
+//Contents is left nil on the result.
+func ReadPortableExecutable(reader io.ReaderAt, size int64) (*structs.PortableExecutable, error) {
+	// offsetOfPEHeaderOffset is the offset in the binary where the PE header is found.
+	const offsetOfPEHeaderOffset = 0x3c
+	if size > math.MaxUint32 {
+		return nil, errors.NewError(1032)
+	}
+	if size < offsetOfPEHeaderOffset+4 {
+		return nil, errors.NewError(1020)
+	}
+
+	dosHeader, err := readAt(reader, 0, offsetOfPEHeaderOffset+4)
+	if err != nil {
+		return nil, err
+	}
+
+	//Read up to the file header first, it tells how long the rest of the headers are.
+	fileHeaderOffset := int64(binary.LittleEndian.Uint32(dosHeader[offsetOfPEHeaderOffset:])) + 4
+	fileHeaderEnd := fileHeaderOffset + int64(unsafe.Sizeof(structs.FileHeader{}))
+	if fileHeaderEnd > size {
+		return nil, errors.NewError(1020)
+	}
+	headers, err := readAt(reader, 0, int(fileHeaderEnd))
+	if err != nil {
+		return nil, err
+	}
+
+	var fileHeader structs.FileHeader
+	if readError := binary.Read(bytes.NewReader(headers[fileHeaderOffset:]), binary.LittleEndian, &fileHeader); readError != nil {
+		return nil, errors.NewError(1024)
+	}
+
+	headersEnd := fileHeaderEnd + int64(fileHeader.SizeOfOptionalHeader) + int64(unsafe.Sizeof(structs.SectionHeader{}))*int64(fileHeader.NumberOfSections)
+	if headersEnd > size {
+		headersEnd = size
+	}
+	if headers, err = readAt(reader, 0, int(headersEnd)); err != nil {
+		return nil, err
+	}
+
+	return parsePortableExecutable(headers, int(size), func(offset, size int) ([]byte, error) {
+		return readAt(reader, int64(offset), size)
+	})
+}
+
+//Reads exactly size bytes at offset.
+func readAt(reader io.ReaderAt, offset int64, size int) ([]byte, error) {
+	buffer := make([]byte, size)
+	if n, err := reader.ReadAt(buffer, offset); n < size {
+		if err == nil || err == io.EOF {
+			return nil, errors.NewError(1020)
+		}
+		return nil, errors.NewError(1034)
+	}
+	return buffer, nil
+}
@@ -0,0 +1,169 @@
+package windows
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+)
+
+//this OID identifies the extension recording what the stub looked like before it was stamped
+var metaPodOriginOID = asn1.ObjectIdentifier([]int{2, 4, 6, 8, 5, 1, 94659, 2, 1, 9002})
+
+//Everything about the original stub that stamping overwrites and that cannot be derived from the stamped file.
+//The certificate table size and WIN_CERTIFICATE length follow from the rebuilt table.
+type carrierOrigin struct {
+	CheckSum  int64
+	TagLength int
+	//Zero bytes that followed the PKCS#7 WIN_CERTIFICATE outside of its dwLength, to align the entry after it.
+	Padding int `asn1:"optional"`
+}
+
+//Decodes the origin extension of a carrier.
+func decodeOrigin(value []byte) (carrierOrigin, error) {
+	var origin carrierOrigin
+	if _, err := asn1.Unmarshal(value, &origin); err != nil {
+		return origin, errors.NewError(1053)
+	}
+	if origin.TagLength < 0 || origin.Padding < 0 || origin.Padding > 7 {
+		return origin, errors.NewError(1053)
+	}
+	return origin, nil
+}
+
+//Describes the stub this executable was built from. A stub that was itself stamped keeps the origin
+//of the file it was stamped from, so stripping always leads back to the released executable.
+func (portableExecutable *TargetExecutable) origin() (pkix.Extension, carrierOrigin, error) {
+	origin := carrierOrigin{
+		CheckSum:  int64(binary.LittleEndian.Uint32(portableExecutable.Headers[portableExecutable.CheckSumOffset:])),
+		TagLength: len(portableExecutable.AppendedTag),
+	}
+	if entries := portableExecutable.AttributeCertificates; len(entries) > 0 {
+		signedData := entries[portableExecutable.SignedDataIndex]
+		origin.Padding = len(signedData.Raw) - int(signedData.Length)
+	}
+
+	carrier := portableExecutable.carrier()
+	if value, found := carrierExtension(carrier, metaPodOriginOID); found {
+		var err error
+		if origin, err = decodeOrigin(value); err != nil {
+			return pkix.Extension{}, origin, err
+		}
+		if origin.TagLength > len(portableExecutable.AppendedTag) {
+			return pkix.Extension{}, origin, errors.NewError(1053)
+		}
+	}
+
+	der, err := asn1.Marshal(origin)
+	if err != nil {
+		return pkix.Extension{}, origin, errors.NewError(1042)
+	}
+	return pkix.Extension{Id: metaPodOriginOID, Value: der}, origin, nil
+}
+
+//Returns a copy of the signature with every carrier certificate and carrier attribute dropped, from the nested
+//signatures too. The executable itself is left untouched.
+func (portableExecutable *TargetExecutable) withoutCarriers() (structs.X509Certificate, error) {
+	return WithoutCarriers(*portableExecutable.X509Certificate)
+}
+
+//WithoutCarriers returns a copy of signedData with every carrier certificate and carrier attribute dropped,
+//from the nested signatures too.
+func WithoutCarriers(signedData structs.X509Certificate) (structs.X509Certificate, error) {
+	signedData, _, err := withoutCarrier(signedData)
+	if err != nil {
+		return signedData, err
+	}
+	nested, err := NestedSignatures(&signedData)
+	if err != nil || len(nested) == 0 {
+		return signedData, err
+	}
+	stamped := false
+	for index := range nested {
+		var changed bool
+		if nested[index], changed, err = withoutCarrier(nested[index]); err != nil {
+			return signedData, err
+		}
+		stamped = stamped || changed
+	}
+	//Nested signatures that never held a carrier are left exactly as they are.
+	if !stamped {
+		return signedData, nil
+	}
+	return withNestedSignatures(signedData, nested)
+}
+
+//Returns a copy of a single signature with its carrier certificates and carrier attribute dropped,
+//and whether it held any of them.
+func withoutCarrier(signedData structs.X509Certificate) (structs.X509Certificate, bool, error) {
+	certificates := signedData.PKCS7.Certificates
+	signedData.PKCS7.Certificates = make([]asn1.RawValue, 0, len(certificates))
+	for _, der := range certificates {
+		if parseCarrier(der) == nil {
+			signedData.PKCS7.Certificates = append(signedData.PKCS7.Certificates, der)
+		}
+	}
+	changed := len(signedData.PKCS7.Certificates) != len(certificates)
+
+	attributes, err := UnauthenticatedAttributes(&signedData)
+	if err != nil {
+		return signedData, changed, err
+	}
+	kept := attributes[:0:0]
+	for _, attribute := range attributes {
+		if !attribute.Type.Equal(metaPodAttributeOID) {
+			kept = append(kept, attribute)
+		}
+	}
+	if len(kept) == len(attributes) {
+		return signedData, changed, nil
+	}
+	signedData, err = withUnauthenticatedAttributes(signedData, kept)
+	return signedData, true, err
+}
+
+//Strip removes the MetaPod carrier certificates and returns the executable as it was before it was stamped,
+//including the original CheckSum and certificate table padding.
+//Files stamped before the origin was recorded are restored on a best effort basis: trailing zero padding is
+//trimmed from the appended tag and the CheckSum is left alone.
+//An executable that carries no payload is returned unchanged.
+func (portableExecutable *TargetExecutable) Strip() ([]byte, error) {
+	carrier := portableExecutable.carrier()
+	if carrier == nil {
+		return append([]byte{}, portableExecutable.Contents...), nil
+	}
+
+	value, hasOrigin := carrierExtension(carrier, metaPodOriginOID)
+	var origin carrierOrigin
+	if hasOrigin {
+		var err error
+		if origin, err = decodeOrigin(value); err != nil {
+			return nil, err
+		}
+		if origin.TagLength > len(portableExecutable.AppendedTag) {
+			return nil, errors.NewError(1053)
+		}
+	}
+
+	stripped, err := portableExecutable.withoutCarriers()
+	if err != nil {
+		return nil, err
+	}
+	asn1Bytes, asnError := asn1.Marshal(stripped)
+	if asnError != nil {
+		return nil, errors.NewError(1042)
+	}
+
+	if !hasOrigin {
+		tag := bytes.TrimRight(portableExecutable.AppendedTag, "\x00")
+		return portableExecutable.restructure(asn1Bytes, tag), nil
+	}
+
+	signedData := certificateTable(asn1Bytes, portableExecutable.AppendedTag[:origin.TagLength])
+	contents := portableExecutable.assemble(append(signedData, make([]byte, origin.Padding)...))
+	binary.LittleEndian.PutUint32(contents[portableExecutable.CheckSumOffset:], uint32(origin.CheckSum))
+	return contents, nil
+}
@@ -0,0 +1,86 @@
+package windows
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/binary"
+
+	"github.com/RainwayApp/metapod/errors"
+)
+
+//Google Omaha tags installers by appending the magic "Gact", the length of the tag as a big endian uint16 and the
+//tag itself after the SignedData of the certificate table. The signature does not cover those bytes, and a stub can
+//find them by scanning the end of its own file without an ASN.1 or X.509 parser.
+var tagMagic = []byte("Gact")
+
+//MaxTagPayloadSize is the largest payload the uint16 length of an appended tag can describe.
+const MaxTagPayloadSize = 0xffff
+
+func encodeTag(payload []byte) ([]byte, error) {
+	if len(payload) > MaxTagPayloadSize {
+		return nil, errors.NewError(1117)
+	}
+	tag := make([]byte, 0, len(tagMagic)+2+len(payload))
+	tag = append(tag, tagMagic...)
+	tag = append(tag, byte(len(payload)>>8), byte(len(payload)))
+	return append(tag, payload...), nil
+}
+
+//Reads the tag at the start of data.
+func decodeTag(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, tagMagic) || len(data) < len(tagMagic)+2 {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(data[len(tagMagic):]))
+	data = data[len(tagMagic)+2:]
+	if length > len(data) {
+		return nil, false
+	}
+	return data[:length], true
+}
+
+//Only a plain payload can go into a tag: the scanner in the stub knows nothing about envelopes, entries or keys.
+func tagPayload(extensions []pkix.Extension) ([]byte, error) {
+	if len(extensions) != 1 || !extensions[0].Id.Equal(metaPodOID) {
+		return nil, errors.NewError(1116)
+	}
+	envelope, err := decodeEnvelope(extensions[0].Value)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.Compressed || envelope.ContentType != "" {
+		return nil, errors.NewError(1116)
+	}
+	return envelope.Payload, nil
+}
+
+//TagPayloadLength returns the length of the payload of the appended tag, as its header gives it, 0 when there is
+//no tag. The magic, the length itself and the zero padding that follows the SignedData are not counted.
+func (portableExecutable *TargetExecutable) TagPayloadLength() int {
+	payload, _ := portableExecutable.appendedTagPayload(portableExecutable.carrier())
+	return len(payload)
+}
+
+//Returns the payload of the appended tag, or false when there is none. When MetaPod stamped the tag the origin
+//says where it starts; tags written by other tools are found by scanning for the magic.
+func (portableExecutable *TargetExecutable) appendedTagPayload(carrier []pkix.Extension) ([]byte, bool) {
+	tag := portableExecutable.AppendedTag
+	if value, found := carrierExtension(carrier, metaPodOriginOID); found {
+		origin, err := decodeOrigin(value)
+		if err != nil || origin.TagLength > len(tag) {
+			return nil, false
+		}
+		return decodeTag(tag[origin.TagLength:])
+	}
+	for offset := bytes.Index(tag, tagMagic); offset >= 0; {
+		if payload, found := decodeTag(tag[offset:]); found {
+			return payload, true
+		}
+		next := bytes.Index(tag[offset+1:], tagMagic)
+		if next < 0 {
+			break
+		}
+		offset += 1 + next
+	}
+	return nil, false
+}
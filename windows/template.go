@@ -0,0 +1,270 @@
+package windows
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/RainwayApp/metapod/errors"
+	"github.com/RainwayApp/metapod/structs"
+)
+
+//Template is a stub reduced to what stamping needs: its signature without any carrier, the origin of the stub
+//and the checksum of the part of the file that stamping leaves alone.
+//A Template is never modified once created, so one Template can stamp from many goroutines at once.
+//The stub is referenced rather than copied and must not change while the Template is in use.
+type Template struct {
+	executable TargetExecutable
+	source     io.ReaderAt
+	origin     pkix.Extension
+
+	//The checksum of everything before the certificate table is only needed when the CheckSum is updated,
+	//so it is computed by the first stamp that asks for it.
+	prefixCheckSumOnce  sync.Once
+	prefixCheckSum      checkSumWriter
+	prefixCheckSumError error
+}
+
+//NewTemplate prepares a portable executable parsed by GetPortableExecutable for repeated stamping.
+func NewTemplate(portableExecutable *structs.PortableExecutable) (*Template, error) {
+	return NewTemplateReaderAt(portableExecutable, bytes.NewReader(portableExecutable.Contents))
+}
+
+//NewTemplateReaderAt prepares a portable executable for repeated stamping, copying the untouched part of the file from source.
+func NewTemplateReaderAt(portableExecutable *structs.PortableExecutable, source io.ReaderAt) (*Template, error) {
+	template := &Template{
+		executable: TargetExecutable{PortableExecutable: *portableExecutable},
+		source:     source,
+	}
+
+	origin, stub, err := template.executable.origin()
+	if err != nil {
+		return nil, err
+	}
+	template.origin = origin
+	//Whatever an earlier stamp appended to the tag of the stub, padding or a tag of its own, is dropped.
+	template.executable.AppendedTag = template.executable.AppendedTag[:stub.TagLength]
+
+	signedData, err := template.executable.withoutCarriers()
+	if err != nil {
+		return nil, err
+	}
+	template.executable.X509Certificate = &signedData
+	return template, nil
+}
+
+//StampPayload creates a new executable carrying payload.
+func (template *Template) StampPayload(payload []byte, key crypto.Signer, updateCheckSum bool) ([]byte, error) {
+	return template.Stamp(PayloadExtensions(payload), key, Layout{}, updateCheckSum)
+}
+
+//StampEntries creates a new executable carrying a set of named entries.
+func (template *Template) StampEntries(entries map[string][]byte, key crypto.Signer, updateCheckSum bool) ([]byte, error) {
+	extensions, err := EntriesExtensions(entries)
+	if err != nil {
+		return nil, err
+	}
+	return template.Stamp(extensions, key, Layout{}, updateCheckSum)
+}
+
+//Stamp creates a new executable carrying extensions where layout says. A carrier certificate is signed with key
+//(the shared key when nil); key is not used when the extensions are embedded as an attribute.
+//When updateCheckSum is set the CheckSum of the result is recomputed, otherwise it is the one of the stub.
+func (template *Template) Stamp(extensions []pkix.Extension, key crypto.Signer, layout Layout, updateCheckSum bool) ([]byte, error) {
+	var contents bytes.Buffer
+	executable := &template.executable
+	contents.Grow(executable.AttrCertOffset + len(executable.Asn1Data) + len(executable.AppendedTag) + 4096)
+	if _, err := template.StampTo(&contents, extensions, key, layout, updateCheckSum); err != nil {
+		return nil, err
+	}
+	return contents.Bytes(), nil
+}
+
+//StampTo is Stamp writing the new executable to writer. The part of the stub before the certificate table is
+//streamed from the source of the template. It returns the number of bytes written.
+func (template *Template) StampTo(writer io.Writer, extensions []pkix.Extension, key crypto.Signer, layout Layout, updateCheckSum bool) (int64, error) {
+	reader, err := template.StampReader(extensions, key, layout, updateCheckSum)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(writer, reader)
+	if err != nil {
+		return written, errors.NewError(1035)
+	}
+	return written, nil
+}
+
+//Stamped is a stamped executable that can be read at any offset. Only the headers and the certificate table are
+//held in memory, everything in between is read from the source of the template on demand.
+type Stamped struct {
+	*io.SectionReader
+	headers []byte
+	table   []byte
+}
+
+//Digest hashes the headers and the certificate table, which is everything stamping changes.
+//The signature within the table covers the digest of the rest of the file, so two stamps of one template
+//have the same Digest exactly when they have the same contents.
+func (stamped *Stamped) Digest() []byte {
+	digest := sha256.New()
+	digest.Write(stamped.headers)
+	digest.Write(stamped.table)
+	return digest.Sum(nil)
+}
+
+//StampReader is Stamp returning the new executable as a reader.
+func (template *Template) StampReader(extensions []pkix.Extension, key crypto.Signer, layout Layout, updateCheckSum bool) (*Stamped, error) {
+	executable := &template.executable
+	signedData, tag := *executable.X509Certificate, executable.AppendedTag
+	var err error
+	if layout.Embedding == EmbedTag {
+		if tag, err = template.appendTag(extensions); err != nil {
+			return nil, err
+		}
+		//The signature only keeps the origin, in an attribute, so that the stub can be restored.
+		signedData, err = withCarrier(signedData, withAttribute([]pkix.Extension{template.origin}), layout.Placement)
+	} else {
+		signedData, err = WithCarrierExtensions(signedData, append(append([]pkix.Extension{}, extensions...), template.origin), key, layout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	asn1Bytes, asnError := asn1.Marshal(signedData)
+	if asnError != nil {
+		return nil, errors.NewError(1042)
+	}
+	table := executable.replaceSignedData(certificateTable(asn1Bytes, padTag(asn1Bytes, tag)))
+
+	headers := append([]byte{}, executable.Headers...)
+	binary.LittleEndian.PutUint32(headers[executable.CertSizeOffset:], uint32(len(table)))
+	if updateCheckSum {
+		checkSum, err := template.checkSumOfPrefix()
+		if err != nil {
+			return nil, err
+		}
+		checkSum.patch(headers[executable.CertSizeOffset:executable.CertSizeOffset+4], executable.CertSizeOffset)
+		checkSum.Write(table)
+		binary.LittleEndian.PutUint32(headers[executable.CheckSumOffset:], checkSum.CheckSum())
+	}
+
+	parts := segments{
+		bytes.NewReader(headers),
+		template.body(),
+		bytes.NewReader(table),
+	}
+	return &Stamped{
+		SectionReader: io.NewSectionReader(parts, 0, int64(executable.AttrCertOffset+len(table))),
+		headers:       headers,
+		table:         table,
+	}, nil
+}
+
+//Returns the tag of the stub followed by a tag holding the plain payload of extensions.
+func (template *Template) appendTag(extensions []pkix.Extension) ([]byte, error) {
+	payload, err := tagPayload(extensions)
+	if err != nil {
+		return nil, err
+	}
+	appended, err := encodeTag(payload)
+	if err != nil {
+		return nil, err
+	}
+	tag := template.executable.AppendedTag
+	return append(tag[:len(tag):len(tag)], appended...), nil
+}
+
+//WithCarrierExtensions returns a copy of signedData carrying extensions in the signatures layout selects.
+//A carrier certificate is signed with key (the shared key when nil). Appended tags live outside of the signature
+//and are left to the caller.
+func WithCarrierExtensions(signedData structs.X509Certificate, extensions []pkix.Extension, key crypto.Signer, layout Layout) (structs.X509Certificate, error) {
+	var edit signatureEdit
+	switch layout.Embedding {
+	case EmbedCertificate:
+		derCert, err := newCarrierCertificate(extensions, key)
+		if err != nil {
+			return signedData, err
+		}
+		edit = withCertificate(derCert)
+	case EmbedAttribute:
+		edit = withAttribute(extensions)
+	default:
+		return signedData, errors.NewError(1115)
+	}
+	return withCarrier(signedData, edit, layout.Placement)
+}
+
+//StampedBytes wraps a file that was stamped in memory, for formats that cannot be streamed from their stub.
+//Its Digest covers the whole file.
+func StampedBytes(contents []byte) *Stamped {
+	return &Stamped{
+		SectionReader: io.NewSectionReader(bytes.NewReader(contents), 0, int64(len(contents))),
+		table:         contents,
+	}
+}
+
+//Everything between the headers and the certificate table, which stamping copies as it is.
+func (template *Template) body() *io.SectionReader {
+	start := int64(len(template.executable.Headers))
+	return io.NewSectionReader(template.source, start, int64(template.executable.AttrCertOffset)-start)
+}
+
+//The CheckSum and the certificate table size are the only bytes before the table that stamping changes,
+//so they are summed as zeros and patched in per stamp.
+func (template *Template) checkSumOfPrefix() (checkSumWriter, error) {
+	template.prefixCheckSumOnce.Do(func() {
+		headers := append([]byte{}, template.executable.Headers...)
+		copy(headers[template.executable.CheckSumOffset:], []byte{0, 0, 0, 0})
+		copy(headers[template.executable.CertSizeOffset:], []byte{0, 0, 0, 0})
+		template.prefixCheckSum.Write(headers)
+		if _, err := io.Copy(&template.prefixCheckSum, template.body()); err != nil {
+			template.prefixCheckSumError = errors.NewError(1034)
+		}
+	})
+	return template.prefixCheckSum, template.prefixCheckSumError
+}
+
+//A file made of consecutive parts.
+type segments []interface {
+	io.ReaderAt
+	Size() int64
+}
+
+func (parts segments) ReadAt(p []byte, offset int64) (n int, err error) {
+	for _, part := range parts {
+		if len(p) == 0 {
+			return n, nil
+		}
+		size := part.Size()
+		if offset >= size {
+			offset -= size
+			continue
+		}
+		read, err := part.ReadAt(p[:min64(int64(len(p)), size-offset)], offset)
+		n += read
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if int64(read) < min64(int64(len(p)), size-offset) {
+			return n, io.ErrUnexpectedEOF
+		}
+		p = p[read:]
+		offset = 0
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -34,8 +34,8 @@ namespace MetaPod_Net
                var output = IntPtr.Zero;
                try
                {
-                   var errorCode = 0;
-                   var outputSize  = NativeWrapper.Create(inputBytes, inputBytes.Length, payloadBytes, ref output, ref errorCode);
+                   var outputSize = 0;
+                   var errorCode = NativeWrapper.CreateEx(inputBytes, inputBytes.Length, payloadBytes, payloadBytes.Length, ref output, ref outputSize);
                    if (errorCode > 0)
                    {
                        throw new MetaPodException(GetErrorCodeMessage(errorCode));
@@ -49,7 +49,7 @@ namespace MetaPod_Net
                }
                finally
                {
-                   Marshal.FreeHGlobal(output);
+                   NativeWrapper.MetaPodFree(output);
                }
            }
    
@@ -63,22 +63,39 @@ namespace MetaPod_Net
            /// <exception cref="ArgumentNullException"></exception>
            /// <exception cref="MetaPodException"></exception>
            public static Span<byte> Create(Span<byte> inputFile, string payload)
+           {
+               if (string.IsNullOrWhiteSpace(payload))
+               {
+                   throw new ArgumentNullException($"Payload string cannot be null or empty.");
+               }
+               return Create(inputFile, Encoding.UTF8.GetBytes(payload));
+           }
+
+           /// <summary>
+           /// This method attempts to create a new executable containing the provided binary payload.
+           /// The payload may contain any bytes, including zeros, so encrypted or serialized data can be embedded.
+           /// </summary>
+           /// <param name="inputFile">The raw bytes of the digitally signed portable executable that metadata will be written to.</param>
+           /// <param name="payload">The metadata that will be written to the portable executable.</param>
+           /// <returns>The new MetaPod portable executable.</returns>
+           /// <exception cref="ArgumentNullException"></exception>
+           /// <exception cref="MetaPodException"></exception>
+           public static Span<byte> Create(Span<byte> inputFile, byte[] payload)
            {
                if (inputFile.Length == 0)
                {
                    throw new ArgumentNullException($"Input file bytes cannot be zero.");
                }
-               if (string.IsNullOrWhiteSpace(payload))
+               if (payload == null)
                {
-                   throw new ArgumentNullException($"Payload string cannot be null or empty.");
+                   throw new ArgumentNullException($"Payload cannot be null.");
                }
-               var payloadBytes = Encoding.UTF8.GetBytes(payload);
                var output = IntPtr.Zero;
                try
                {
                    var outputSize = 0;
                    
-                   var errorCode  = NativeWrapper.Create(inputFile.ToArray(), inputFile.Length, payloadBytes, ref output, ref outputSize);
+                   var errorCode  = NativeWrapper.CreateEx(inputFile.ToArray(), inputFile.Length, payload, payload.Length, ref output, ref outputSize);
                    if (errorCode > 0)
                    {
                        throw new MetaPodException(GetErrorCodeMessage(errorCode));
@@ -89,7 +106,7 @@ namespace MetaPod_Net
                }
                finally
                {
-                   Marshal.FreeHGlobal(output);
+                   NativeWrapper.MetaPodFree(output);
                }
            }
    
@@ -112,7 +129,7 @@ namespace MetaPod_Net
                var payloadSize = 0;
                try
                {
-                   var errorCode = NativeWrapper.Open(inputBytes, inputBytes.Length, ref output, ref payloadSize);
+                   var errorCode = NativeWrapper.OpenEx(inputBytes, inputBytes.Length, ref output, ref payloadSize);
                    if (errorCode > 0)
                    {
                        throw new MetaPodException(GetErrorCodeMessage(errorCode));
@@ -123,7 +140,7 @@ namespace MetaPod_Net
                }
                finally
                {
-                   Marshal.FreeHGlobal(output);
+                   NativeWrapper.MetaPodFree(output);
                }
            }
    
@@ -134,23 +151,37 @@ namespace MetaPod_Net
            /// <param name="inputFile"></param>
            /// <returns>The MetaPod payload as a string.</returns>
            public static string Open(Span<byte> inputFile)
+           {
+               return Encoding.UTF8.GetString(OpenBytes(inputFile));
+           }
+
+           /// <summary>
+           /// This method attempts to open and read the binary payload of a MetaPod portable executable from a provided <see cref="Span{T}"/>
+           /// If no payload is found a  <see cref="MetaPodException"/> will be thrown.
+           /// </summary>
+           /// <param name="inputFile"></param>
+           /// <returns>The MetaPod payload exactly as it was written.</returns>
+           public static byte[] OpenBytes(Span<byte> inputFile)
            {
                var output = IntPtr.Zero;
                var payloadSize = 0;
                try
                {
-                   var errorCode = NativeWrapper.Open(inputFile.ToArray(), inputFile.Length, ref output, ref payloadSize);
+                   var errorCode = NativeWrapper.OpenEx(inputFile.ToArray(), inputFile.Length, ref output, ref payloadSize);
                    if (errorCode > 0)
                    {
                        throw new MetaPodException(GetErrorCodeMessage(errorCode));
                    }
                    var byteArray = new byte[payloadSize];
-                   Marshal.Copy(output, byteArray, 0, payloadSize);
-                   return Encoding.UTF8.GetString(byteArray);
+                   if (payloadSize > 0)
+                   {
+                       Marshal.Copy(output, byteArray, 0, payloadSize);
+                   }
+                   return byteArray;
                }
                finally
                {
-                   Marshal.FreeHGlobal(output);
+                   NativeWrapper.MetaPodFree(output);
                }
            }
    
@@ -159,7 +190,7 @@ namespace MetaPod_Net
            /// </summary>
            /// <param name="errorCode"></param>
            /// <returns>The MetaPod error message.</returns>
-           private static string GetErrorCodeMessage(int errorCode)
+           internal static string GetErrorCodeMessage(int errorCode)
            {
                var output = IntPtr.Zero;
                try
@@ -171,7 +202,7 @@ namespace MetaPod_Net
                }
                finally
                {
-                   Marshal.FreeHGlobal(output);
+                   NativeWrapper.MetaPodFree(output);
                }
            }
        }
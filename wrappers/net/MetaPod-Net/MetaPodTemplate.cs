@@ -0,0 +1,106 @@
+using System;
+using System.Runtime.InteropServices;
+using System.Text;
+
+namespace MetaPod_Net
+{
+    /// <summary>
+    /// A digitally signed template that is parsed once and can then be stamped with any number of payloads,
+    /// from any number of threads at once.
+    /// </summary>
+    public sealed class MetaPodTemplate : IDisposable
+    {
+        private UIntPtr _handle;
+
+        /// <summary>
+        /// Loads a template from the raw bytes of a digitally signed portable executable.
+        /// </summary>
+        /// <param name="inputFile">The raw bytes of the digitally signed portable executable.</param>
+        /// <exception cref="ArgumentNullException"></exception>
+        /// <exception cref="MetaPodException"></exception>
+        public MetaPodTemplate(Span<byte> inputFile)
+        {
+            if (inputFile.Length == 0)
+            {
+                throw new ArgumentNullException($"Input file bytes cannot be zero.");
+            }
+            var errorCode = NativeWrapper.TemplateLoad(inputFile.ToArray(), inputFile.Length, ref _handle);
+            if (errorCode > 0)
+            {
+                throw new MetaPodException(MetaPod.GetErrorCodeMessage(errorCode));
+            }
+        }
+
+        /// <summary>
+        /// Creates a new executable containing the provided payload.
+        /// </summary>
+        /// <param name="payload">The metadata that will be written to the portable executable.</param>
+        /// <returns>The new MetaPod portable executable.</returns>
+        /// <exception cref="ArgumentNullException"></exception>
+        /// <exception cref="ObjectDisposedException"></exception>
+        /// <exception cref="MetaPodException"></exception>
+        public byte[] Stamp(string payload)
+        {
+            if (string.IsNullOrWhiteSpace(payload))
+            {
+                throw new ArgumentNullException($"Payload string cannot be null or empty.");
+            }
+            return Stamp(Encoding.UTF8.GetBytes(payload));
+        }
+
+        /// <summary>
+        /// Creates a new executable containing the provided binary payload.
+        /// </summary>
+        /// <param name="payload">The metadata that will be written to the portable executable.</param>
+        /// <returns>The new MetaPod portable executable.</returns>
+        /// <exception cref="ArgumentNullException"></exception>
+        /// <exception cref="ObjectDisposedException"></exception>
+        /// <exception cref="MetaPodException"></exception>
+        public byte[] Stamp(byte[] payload)
+        {
+            if (payload == null)
+            {
+                throw new ArgumentNullException($"Payload cannot be null.");
+            }
+            if (_handle == UIntPtr.Zero)
+            {
+                throw new ObjectDisposedException(nameof(MetaPodTemplate));
+            }
+            var output = IntPtr.Zero;
+            try
+            {
+                var outputSize = 0;
+                var errorCode = NativeWrapper.TemplateStamp(_handle, payload, payload.Length, ref output, ref outputSize);
+                if (errorCode > 0)
+                {
+                    throw new MetaPodException(MetaPod.GetErrorCodeMessage(errorCode));
+                }
+                var outputBuffer = new byte[outputSize];
+                Marshal.Copy(output, outputBuffer, 0, outputSize);
+                return outputBuffer;
+            }
+            finally
+            {
+                NativeWrapper.MetaPodFree(output);
+            }
+        }
+
+        /// <summary>
+        /// Releases the native template.
+        /// </summary>
+        public void Dispose()
+        {
+            if (_handle != UIntPtr.Zero)
+            {
+                NativeWrapper.TemplateFree(_handle);
+                _handle = UIntPtr.Zero;
+            }
+            GC.SuppressFinalize(this);
+        }
+
+        ~MetaPodTemplate()
+        {
+            Dispose();
+        }
+    }
+}
@@ -22,6 +22,17 @@ namespace MetaPod_Net
         [DllImport(LibraryName, CharSet = CharSet.Unicode, CallingConvention = CallingConvention.Cdecl)]
         internal static extern int Open(byte[] portableExecutable, int portableExecutableSize, ref IntPtr payload, ref int payloadSize);
 
+        /// <summary>
+        /// Opens a MetaPod executable for the purpose of reading its payload as raw bytes.
+        /// </summary>
+        /// <param name="portableExecutable">The MetaPod portable executable buffer.</param>
+        /// <param name="portableExecutableSize">The total size of the MetaPod executable.</param>
+        /// <param name="payload">A managed pointer to the payload buffer.</param>
+        /// <param name="payloadSize">The length of the payload buffer.</param>
+        /// <returns>The error code, if any.</returns>
+        [DllImport(LibraryName, CallingConvention = CallingConvention.Cdecl)]
+        internal static extern int OpenEx(byte[] portableExecutable, int portableExecutableSize, ref IntPtr payload, ref int payloadSize);
+
         /// <summary>
         /// Grabs the human-readable error message from an error code.
         /// </summary>
@@ -30,6 +41,45 @@ namespace MetaPod_Net
         /// <returns></returns>
         [DllImport(LibraryName, CharSet = CharSet.Unicode, CallingConvention = CallingConvention.Cdecl)]
         internal static extern int GetErrorCodeMessage(int errorCode, ref IntPtr errorMessage);
+
+        /// <summary>
+        /// Releases a buffer returned by any of the other functions. Every output pointer must be released with this,
+        /// never with <see cref="Marshal.FreeHGlobal"/>, since the library allocates with the C allocator.
+        /// Passing <see cref="IntPtr.Zero"/> does nothing.
+        /// </summary>
+        /// <param name="pointer">The buffer to release.</param>
+        [DllImport(LibraryName, CallingConvention = CallingConvention.Cdecl)]
+        internal static extern void MetaPodFree(IntPtr pointer);
+
+        /// <summary>
+        /// Parses a digitally signed template once so it can be stamped many times.
+        /// </summary>
+        /// <param name="template">The input/template portable executable.</param>
+        /// <param name="templateSize">The total size of the input file.</param>
+        /// <param name="handle">The handle of the loaded template.</param>
+        /// <returns>The error code, if any.</returns>
+        [DllImport(LibraryName, CallingConvention = CallingConvention.Cdecl)]
+        internal static extern int TemplateLoad(byte[] template, int templateSize, ref UIntPtr handle);
+
+        /// <summary>
+        /// Creates a MetaPod portable executable from a loaded template. Safe to call from several threads at once.
+        /// </summary>
+        /// <param name="handle">The handle returned by <see cref="TemplateLoad"/>.</param>
+        /// <param name="payload">The payload buffer.</param>
+        /// <param name="payloadSize">The length of the payload buffer.</param>
+        /// <param name="output">A managed pointer to the portable executable buffer.</param>
+        /// <param name="outputSize">The total bytes of the final portable executable.</param>
+        /// <returns>The error code, if any.</returns>
+        [DllImport(LibraryName, CallingConvention = CallingConvention.Cdecl)]
+        internal static extern int TemplateStamp(UIntPtr handle, byte[] payload, int payloadSize, ref IntPtr output, ref int outputSize);
+
+        /// <summary>
+        /// Releases a loaded template.
+        /// </summary>
+        /// <param name="handle">The handle returned by <see cref="TemplateLoad"/>.</param>
+        /// <returns>The error code, if any.</returns>
+        [DllImport(LibraryName, CallingConvention = CallingConvention.Cdecl)]
+        internal static extern int TemplateFree(UIntPtr handle);
         
         /// <summary>
         /// Creates a MetaPod portable executable from a base template. The template must already be digitally signed.
@@ -42,5 +92,19 @@ namespace MetaPod_Net
         /// <returns>The error code, if any.</returns>
         [DllImport(LibraryName, CharSet = CharSet.Unicode, CallingConvention = CallingConvention.Cdecl)]
         internal static extern int Create(byte[] template, int templateSize, byte[] payload, ref IntPtr output, ref int outputSize);
+
+        /// <summary>
+        /// Creates a MetaPod portable executable from a base template. The template must already be digitally signed.
+        /// The payload is passed with its length, so it may contain any bytes.
+        /// </summary>
+        /// <param name="template">The input/template portable executable.</param>
+        /// <param name="templateSize">The total size of the input file.</param>
+        /// <param name="payload">The payload buffer.</param>
+        /// <param name="payloadSize">The length of the payload buffer.</param>
+        /// <param name="output">A managed pointer to the portable executable buffer.</param>
+        /// <param name="outputSize">The total bytes of the final portable executable.</param>
+        /// <returns>The error code, if any.</returns>
+        [DllImport(LibraryName, CallingConvention = CallingConvention.Cdecl)]
+        internal static extern int CreateEx(byte[] template, int templateSize, byte[] payload, int payloadSize, ref IntPtr output, ref int outputSize);
     }
 }
\ No newline at end of file